@@ -20,33 +20,58 @@ import (
 	"context"
 	goflag "flag"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/golang/glog"
 	blended "github.com/inwinstack/blended/generated/clientset/versioned"
 	"github.com/inwinstack/pa-svc-syncker/pkg/config"
+	"github.com/inwinstack/pa-svc-syncker/pkg/constants"
+	"github.com/inwinstack/pa-svc-syncker/pkg/health"
+	"github.com/inwinstack/pa-svc-syncker/pkg/metrics"
 	"github.com/inwinstack/pa-svc-syncker/pkg/operator"
+	"github.com/inwinstack/pa-svc-syncker/pkg/plan"
 	"github.com/inwinstack/pa-svc-syncker/pkg/version"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	flag "github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/transport"
 )
 
+// The period of checking whether the config file is changed
+const configCheckPeriod = time.Second * 10
+
 var (
-	cfg        = &config.Config{}
-	kubeconfig string
-	ver        bool
+	cfg           = &config.Config{}
+	kubeconfig    string
+	configFile    string
+	listenAddress string
+	ver           bool
+
+	// The service objects are generated from the ports of Services, so the value is ignored
+	services []string
 )
 
 func parserFlags() {
 	flag.StringVarP(&kubeconfig, "kubeconfig", "", "", "Absolute path to the kubeconfig file.")
+	flag.StringVarP(&configFile, "config", "", "", "Path to the YAML or JSON config file, which overrides the flags and is reloaded on change.")
+	flag.StringVarP(&listenAddress, "listen-address", "", ":8080", "The address to serve the HTTP endpoints of metrics and health probes.")
 	flag.IntVarP(&cfg.Threads, "threads", "", 2, "Number of worker threads used by the controller.")
 	flag.IntVarP(&cfg.SyncSec, "sync-seconds", "", 30, "Seconds for syncing and retrying objects.")
-	flag.StringSliceVarP(&cfg.IgnoreNamespaces, "ignore-namespaces", "", nil, "Ignore namespaces for syncing objects.")
-	flag.StringSliceVarP(&cfg.Services, "services", "", []string{"k8s-tcp", "k8s-udp"}, "The service objects of security policy.")
+	flag.StringSliceVarP(&cfg.IgnoreNamespaces, "ignore-namespaces", "", nil, "Ignore namespaces for syncing objects, which can be glob patterns, e.g. kube-*.")
+	flag.StringVarP(&cfg.NamespaceSelector, "namespace-selector", "", "", "The label selector of namespaces to sync, empty means all namespaces.")
+	flag.StringVarP(&cfg.ServiceSelector, "service-selector", "", "", "The label selector of Services to sync, empty means all Services.")
+	flag.StringSliceVarP(&services, "services", "", nil, "The service objects of security policy.")
+	flag.CommandLine.MarkDeprecated("services", "the service objects are generated from the ports of Services, and the value is ignored")
 	flag.StringSliceVarP(&cfg.SourceZones, "source-zones", "", []string{"untrust"}, "The source zones of security policy.")
 	flag.StringSliceVarP(&cfg.DestinationZones, "destination-zones", "", []string{"AI public service network"}, "The destination zones of security policy.")
 	flag.StringSliceVarP(&cfg.SourceUsers, "source-users", "", []string{"any"}, "The source users of security policy.")
@@ -55,6 +80,20 @@ func parserFlags() {
 	flag.StringSliceVarP(&cfg.Categories, "categories", "", []string{"any"}, "The categories of security policy.")
 	flag.StringVarP(&cfg.LogSettingName, "log-setting", "", "", "The log-setting name of security policy.")
 	flag.StringVarP(&cfg.GroupName, "group", "", "", "The group name of security policy.")
+	flag.StringSliceVarP(&cfg.ServiceTypes, "service-types", "", []string{}, "The types of Services to sync, empty means all types. The synced Services are kept regardless of their types.")
+	flag.StringVarP(&cfg.OptInKey, "opt-in-key", "", constants.SyncOptInKey, "The key of Service label or annotation for opting in (\"true\") or out (\"false\") of syncing regardless of the type.")
+	flag.BoolVarP(&cfg.SyncPolicy, "sync-policy", "", false, "Enable the SyncPolicy resources to override the default policy flags.")
+	flag.BoolVarP(&cfg.WhitelistSets, "whitelist-sets", "", false, "Enable the WhitelistSet resources referenced by the whitelist sets annotation of namespaces.")
+	flag.BoolVarP(&cfg.DryRun, "dry-run", "", false, "Only report the changes of NAT and Security on /plan without writing them.")
+	flag.BoolVarP(&cfg.LeaderElect, "leader-elect", "", false, "Enable leader election for running multiple replicas.")
+	flag.StringVarP(&cfg.LeaderElectLockType, "leader-elect-lock-type", "", resourcelock.LeasesResourceLock, "The type of resource lock for leader election, one of leases or configmaps.")
+	flag.StringVarP(&cfg.LeaderElectNamespace, "leader-elect-namespace", "", "kube-system", "The namespace of resource lock for leader election.")
+	flag.DurationVarP(&cfg.LeaderElectLeaseDuration.Duration, "leader-elect-lease-duration", "", 15*time.Second, "The duration that non-leader candidates will wait before forcing to acquire leadership.")
+	flag.DurationVarP(&cfg.LeaderElectRenewDeadline.Duration, "leader-elect-renew-deadline", "", 10*time.Second, "The duration that the leader will retry refreshing leadership before giving up.")
+	flag.DurationVarP(&cfg.LeaderElectRetryPeriod.Duration, "leader-elect-retry-period", "", 2*time.Second, "The duration the clients should wait between tries of actions.")
+	flag.DurationVarP(&cfg.GCPeriod.Duration, "gc-period", "", 10*time.Minute, "The period of deleting the orphaned NAT, Security and service objects, 0 disables the garbage collection.")
+	flag.BoolVarP(&cfg.GCReportOnly, "gc-report-only", "", false, "Only report the orphaned objects by logs and metrics without deleting them.")
+	flag.DurationVarP(&cfg.HealthCheckWindow.Duration, "health-check-window", "", 3*time.Minute, "The window that workers haven't processed any item or blended client has failed before reporting unhealthy.")
 	flag.BoolVarP(&ver, "version", "", false, "Display the version.")
 	flag.CommandLine.AddGoFlagSet(goflag.CommandLine)
 	flag.Parse()
@@ -76,6 +115,62 @@ func restConfig(kubeconfig string) (*rest.Config, error) {
 	return cfg, nil
 }
 
+func serveHTTP(addr string, changes *plan.Plan, checkers ...health.Checker) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	if changes != nil {
+		mux.Handle("/plan", changes.Handler())
+	}
+	mux.Handle("/healthz", health.HealthzHandler(checkers...))
+	mux.Handle("/readyz", health.ReadyzHandler(checkers...))
+
+	glog.Infof("Serving HTTP endpoints on %s", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		glog.Fatalf("Failed to serve HTTP endpoints: %s", err.Error())
+	}
+}
+
+func runWithLeaderElection(ctx context.Context, client kubernetes.Interface, run func(ctx context.Context)) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		glog.Fatalf("Failed to get hostname: %s", err.Error())
+	}
+	id := hostname + "_" + string(uuid.NewUUID())
+
+	lock, err := resourcelock.New(
+		cfg.LeaderElectLockType,
+		cfg.LeaderElectNamespace,
+		constants.ManagedByValue,
+		client.CoreV1(),
+		client.CoordinationV1(),
+		resourcelock.ResourceLockConfig{Identity: id},
+	)
+	if err != nil {
+		glog.Fatalf("Failed to create resource lock: %s", err.Error())
+	}
+
+	leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   cfg.LeaderElectLeaseDuration.Duration,
+		RenewDeadline:   cfg.LeaderElectRenewDeadline.Duration,
+		RetryPeriod:     cfg.LeaderElectRetryPeriod.Duration,
+		ReleaseOnCancel: true,
+		Name:            constants.ManagedByValue,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: run,
+			OnStoppedLeading: func() {
+				if ctx.Err() == nil {
+					glog.Fatalf("Leader election lost: %s", id)
+				}
+				glog.Infof("Released the leadership: %s", id)
+			},
+			OnNewLeader: func(identity string) {
+				glog.Infof("New leader elected: %s", identity)
+			},
+		},
+	})
+}
+
 func main() {
 	defer glog.Flush()
 	parserFlags()
@@ -85,6 +180,18 @@ func main() {
 		os.Exit(0)
 	}
 
+	var getter config.Getter = cfg
+	var watcher *config.Watcher
+	if configFile != "" {
+		w, err := config.NewWatcher(configFile, cfg, configCheckPeriod)
+		if err != nil {
+			glog.Fatalf("Failed to load config file: %s", err.Error())
+		}
+		watcher, getter, cfg = w, w, w.Get()
+	} else if err := cfg.Validate(); err != nil {
+		glog.Fatalf("Failed to validate flags: %s", err.Error())
+	}
+
 	k8scfg, err := restConfig(kubeconfig)
 	if err != nil {
 		glog.Fatalf("Failed to build kubeconfig: %s", err.Error())
@@ -95,7 +202,15 @@ func main() {
 		glog.Fatalf("Failed to build Kubernetes client: %s", err.Error())
 	}
 
-	blendedclient, err := blended.NewForConfig(k8scfg)
+	dynamicclient, err := dynamic.NewForConfig(k8scfg)
+	if err != nil {
+		glog.Fatalf("Failed to build dynamic client: %s", err.Error())
+	}
+
+	probe := health.NewClientProbe(getter)
+	blendedcfg := rest.CopyConfig(k8scfg)
+	blendedcfg.WrapTransport = transport.Wrappers(metrics.WrapBlendedTransport, probe.Wrap)
+	blendedclient, err := blended.NewForConfig(blendedcfg)
 	if err != nil {
 		glog.Fatalf("Failed to build Blended client: %s", err.Error())
 	}
@@ -104,13 +219,51 @@ func main() {
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
 
-	op := operator.New(cfg, client, blendedclient)
-	if err := op.Run(ctx); err != nil {
-		glog.Fatalf("Error serving operator instance: %s.", err)
+	op := operator.New(getter, client, blendedclient, dynamicclient)
+	if err := op.RegisterMetrics(); err != nil {
+		glog.Fatalf("Failed to register metrics: %s", err.Error())
+	}
+
+	// The dry-run syncker doesn't write anything, so it can run alongside the leader
+	leaderElect := cfg.LeaderElect && !cfg.DryRun
+	var checker health.Checker = op
+	leader := health.NewLeaderProbe(op)
+	if leaderElect {
+		checker = leader
+	}
+	go serveHTTP(listenAddress, op.Plan(), checker, probe)
+
+	if watcher != nil {
+		watcher.OnChange(func(old, new *config.Config) {
+			op.Resync()
+		})
+		go watcher.Run(ctx)
+	}
+
+	run := func(ctx context.Context) {
+		if err := op.Run(ctx); err != nil {
+			glog.Fatalf("Error serving operator instance: %s.", err)
+		}
+	}
+
+	// The done channel makes sure the leadership is released before exiting
+	done := make(chan struct{})
+	if leaderElect {
+		go func() {
+			defer close(done)
+			runWithLeaderElection(ctx, client, func(ctx context.Context) {
+				leader.SetLeading()
+				run(ctx)
+			})
+		}()
+	} else {
+		run(ctx)
+		close(done)
 	}
 
 	<-signalChan
 	cancel()
+	<-done
 	op.Stop()
 	glog.Infof("Shutdown signal received, exiting...")
 }
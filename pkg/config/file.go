@@ -0,0 +1,135 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"sigs.k8s.io/yaml"
+)
+
+// Load loads the YAML or JSON config file on top of the base config
+func Load(path string, base *Config) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data, base)
+}
+
+// Parse parses the YAML or JSON config on top of the base config, the fields which are absent
+// keep the values of base. The unknown fields and the invalid values are rejected.
+func Parse(data []byte, base *Config) (*Config, error) {
+	js, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config: %s", err.Error())
+	}
+
+	cfg := base.DeepCopy()
+	decoder := json.NewDecoder(bytes.NewReader(js))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %s", err.Error())
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Validate checks whether the config is valid
+func (c *Config) Validate() error {
+	if c.Threads < 1 {
+		return fmt.Errorf("invalid config: threads must be greater than 0")
+	}
+
+	if c.SyncSec < 0 {
+		return fmt.Errorf("invalid config: syncSeconds can't be negative")
+	}
+
+	lists := map[string][]string{
+		"sourceZones":      c.SourceZones,
+		"sourceUsers":      c.SourceUsers,
+		"hipProfiles":      c.HipProfiles,
+		"applications":     c.Applications,
+		"categories":       c.Categories,
+		"ignoreNamespaces": c.IgnoreNamespaces,
+		"destinationZones": c.DestinationZones,
+		"serviceTypes":     c.ServiceTypes,
+	}
+	for name, list := range lists {
+		for _, item := range list {
+			if len(strings.TrimSpace(item)) == 0 {
+				return fmt.Errorf("invalid config: %s has empty item", name)
+			}
+		}
+	}
+
+	for _, pattern := range c.IgnoreNamespaces {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid config: ignoreNamespaces has invalid pattern '%s'", pattern)
+		}
+	}
+
+	selectors := map[string]string{
+		"namespaceSelector": c.NamespaceSelector,
+		"serviceSelector":   c.ServiceSelector,
+	}
+	for name, selector := range selectors {
+		if _, err := labels.Parse(selector); err != nil {
+			return fmt.Errorf("invalid config: %s is invalid: %s", name, err.Error())
+		}
+	}
+
+	for _, t := range c.ServiceTypes {
+		switch v1.ServiceType(t) {
+		case v1.ServiceTypeClusterIP, v1.ServiceTypeNodePort, v1.ServiceTypeLoadBalancer, v1.ServiceTypeExternalName:
+		default:
+			return fmt.Errorf("invalid config: unknown service type '%s'", t)
+		}
+	}
+
+	if c.LeaderElect {
+		switch c.LeaderElectLockType {
+		case resourcelock.LeasesResourceLock, resourcelock.ConfigMapsResourceLock:
+		default:
+			return fmt.Errorf("invalid config: unknown leaderElectLockType '%s'", c.LeaderElectLockType)
+		}
+
+		if c.LeaderElectRenewDeadline.Duration >= c.LeaderElectLeaseDuration.Duration {
+			return fmt.Errorf("invalid config: leaderElectRenewDeadline must be less than leaderElectLeaseDuration")
+		}
+	}
+
+	if c.HealthCheckWindow.Duration < 0 {
+		return fmt.Errorf("invalid config: healthCheckWindow can't be negative")
+	}
+
+	if c.GCPeriod.Duration < 0 {
+		return fmt.Errorf("invalid config: gcPeriod can't be negative")
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newBaseConfig() *Config {
+	return &Config{
+		Threads:                  2,
+		SyncSec:                  30,
+		SourceZones:              []string{"untrust"},
+		DestinationZones:         []string{"AI public service network"},
+		Applications:             []string{"any"},
+		LeaderElectLockType:      "leases",
+		LeaderElectLeaseDuration: metav1.Duration{Duration: 15 * time.Second},
+		LeaderElectRenewDeadline: metav1.Duration{Duration: 10 * time.Second},
+		HealthCheckWindow:        metav1.Duration{Duration: 3 * time.Minute},
+	}
+}
+
+func TestParse(t *testing.T) {
+	base := newBaseConfig()
+	data := []byte(`
+threads: 4
+sourceZones: [trust, untrust]
+logSetting: tenant-log
+healthCheckWindow: 5m
+serviceTypes: [LoadBalancer, NodePort]
+`)
+
+	cfg, err := Parse(data, base)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, cfg.Threads)
+	assert.Equal(t, []string{"trust", "untrust"}, cfg.SourceZones)
+	assert.Equal(t, "tenant-log", cfg.LogSettingName)
+	assert.Equal(t, 5*time.Minute, cfg.HealthCheckWindow.Duration)
+	assert.Equal(t, []string{"LoadBalancer", "NodePort"}, cfg.ServiceTypes)
+
+	// The absent fields keep the values of base, and base isn't changed
+	assert.Equal(t, 30, cfg.SyncSec)
+	assert.Equal(t, []string{"any"}, cfg.Applications)
+	assert.Equal(t, newBaseConfig(), base)
+
+	// JSON is also accepted
+	cfg, err = Parse([]byte(`{"destinationZones": ["tenant zone"]}`), base)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"tenant zone"}, cfg.DestinationZones)
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := []string{
+		`threads: [`,
+		`unknownField: true`,
+		`threads: 0`,
+		`syncSeconds: -1`,
+		`sourceZones: ["untrust", ""]`,
+		`healthCheckWindow: 3 minutes`,
+		`{leaderElect: true, leaderElectLockType: unknown}`,
+		`{leaderElect: true, leaderElectLockType: endpoints}`,
+		`{leaderElect: true, leaderElectRenewDeadline: 20s}`,
+		`serviceTypes: [LoadBalancer, Headless]`,
+		`ignoreNamespaces: ["kube-["]`,
+		`namespaceSelector: "tenant in (a"`,
+		`serviceSelector: "=web"`,
+	}
+
+	for _, test := range tests {
+		cfg, err := Parse([]byte(test), newBaseConfig())
+		assert.NotNil(t, err, test)
+		assert.Nil(t, cfg)
+	}
+}
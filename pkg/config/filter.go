@@ -0,0 +1,57 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"path"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// IgnoresNamespace checks whether the namespace matches any of the glob patterns of ignore namespaces
+func (c *Config) IgnoresNamespace(name string) bool {
+	for _, pattern := range c.IgnoreNamespaces {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// SelectsNamespace checks whether the namespace isn't ignored and matches the namespace selector
+func (c *Config) SelectsNamespace(ns *v1.Namespace) bool {
+	if c.IgnoresNamespace(ns.Name) {
+		return false
+	}
+	return matchSelector(c.NamespaceSelector, ns.Labels)
+}
+
+// SelectsService checks whether the service matches the service selector
+func (c *Config) SelectsService(svc *v1.Service) bool {
+	return matchSelector(c.ServiceSelector, svc.Labels)
+}
+
+// matchSelector matches the labels by the selector, the empty selector matches everything. The
+// selector has been validated, so the invalid one matches nothing.
+func matchSelector(selector string, set map[string]string) bool {
+	s, err := labels.Parse(selector)
+	if err != nil {
+		return false
+	}
+	return s.Matches(labels.Set(set))
+}
@@ -0,0 +1,61 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSelectsNamespace(t *testing.T) {
+	cfg := &Config{
+		IgnoreNamespaces:  []string{"kube-*", "default"},
+		NamespaceSelector: "tenant=true",
+	}
+
+	tests := []struct {
+		Name     string
+		Labels   map[string]string
+		Expected bool
+	}{
+		{Name: "tenant-a", Labels: map[string]string{"tenant": "true"}, Expected: true},
+		{Name: "tenant-b", Labels: map[string]string{"tenant": "false"}, Expected: false},
+		{Name: "tenant-c", Expected: false},
+		{Name: "kube-system", Labels: map[string]string{"tenant": "true"}, Expected: false},
+		{Name: "default", Labels: map[string]string{"tenant": "true"}, Expected: false},
+	}
+
+	for _, test := range tests {
+		ns := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: test.Name, Labels: test.Labels}}
+		assert.Equal(t, test.Expected, cfg.SelectsNamespace(ns), test.Name)
+	}
+
+	assert.True(t, cfg.IgnoresNamespace("kube-public"))
+	assert.False(t, cfg.IgnoresNamespace("tenant-kube"))
+	assert.True(t, (&Config{}).SelectsNamespace(&v1.Namespace{}))
+}
+
+func TestSelectsService(t *testing.T) {
+	cfg := &Config{ServiceSelector: "app in (web, api),!internal"}
+	assert.True(t, cfg.SelectsService(&v1.Service{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "web"}}}))
+	assert.False(t, cfg.SelectsService(&v1.Service{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "web", "internal": ""}}}))
+	assert.False(t, cfg.SelectsService(&v1.Service{}))
+	assert.True(t, (&Config{}).SelectsService(&v1.Service{}))
+}
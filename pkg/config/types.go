@@ -16,18 +16,75 @@ limitations under the License.
 
 package config
 
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Getter provides the current config, which may be reloaded at runtime
+type Getter interface {
+	Get() *Config
+}
+
 // Config contains the operator config
 type Config struct {
-	Threads          int
-	SyncSec          int
-	SourceZones      []string
-	SourceUsers      []string
-	HipProfiles      []string
-	Applications     []string
-	Categories       []string
-	IgnoreNamespaces []string
-	Services         []string
-	DestinationZones []string
-	LogSettingName   string
-	GroupName        string
+	Threads          int      `json:"threads"`
+	SyncSec          int      `json:"syncSeconds"`
+	SourceZones      []string `json:"sourceZones"`
+	SourceUsers      []string `json:"sourceUsers"`
+	HipProfiles      []string `json:"hipProfiles"`
+	Applications     []string `json:"applications"`
+	Categories       []string `json:"categories"`
+	IgnoreNamespaces []string `json:"ignoreNamespaces"`
+	DestinationZones []string `json:"destinationZones"`
+	LogSettingName   string   `json:"logSetting"`
+	GroupName        string   `json:"group"`
+	SyncPolicy       bool     `json:"syncPolicy"`
+	WhitelistSets    bool     `json:"whitelistSets"`
+	DryRun           bool     `json:"dryRun"`
+
+	// The Services of the types, or opted in by the label or annotation, are managed
+	ServiceTypes []string `json:"serviceTypes"`
+	OptInKey     string   `json:"optInKey"`
+
+	// The label selectors of namespaces and Services to sync, the empty selector selects everything
+	NamespaceSelector string `json:"namespaceSelector"`
+	ServiceSelector   string `json:"serviceSelector"`
+
+	LeaderElect              bool            `json:"leaderElect"`
+	LeaderElectLockType      string          `json:"leaderElectLockType"`
+	LeaderElectNamespace     string          `json:"leaderElectNamespace"`
+	LeaderElectLeaseDuration metav1.Duration `json:"leaderElectLeaseDuration"`
+	LeaderElectRenewDeadline metav1.Duration `json:"leaderElectRenewDeadline"`
+	LeaderElectRetryPeriod   metav1.Duration `json:"leaderElectRetryPeriod"`
+
+	HealthCheckWindow metav1.Duration `json:"healthCheckWindow"`
+
+	GCPeriod     metav1.Duration `json:"gcPeriod"`
+	GCReportOnly bool            `json:"gcReportOnly"`
+}
+
+// Get returns the config itself, so that the static config can be used as Getter
+func (c *Config) Get() *Config {
+	return c
+}
+
+// DeepCopy returns a deep copy of the config
+func (c *Config) DeepCopy() *Config {
+	out := *c
+	out.SourceZones = copyList(c.SourceZones)
+	out.SourceUsers = copyList(c.SourceUsers)
+	out.HipProfiles = copyList(c.HipProfiles)
+	out.Applications = copyList(c.Applications)
+	out.Categories = copyList(c.Categories)
+	out.IgnoreNamespaces = copyList(c.IgnoreNamespaces)
+	out.DestinationZones = copyList(c.DestinationZones)
+	out.ServiceTypes = copyList(c.ServiceTypes)
+	return &out
+}
+
+func copyList(list []string) []string {
+	if list == nil {
+		return nil
+	}
+	return append([]string{}, list...)
 }
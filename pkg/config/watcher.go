@@ -0,0 +1,149 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// The fields which are only used on startup, so the changes take effect after restart
+var restartFields = []string{
+	"Threads",
+	"SyncSec",
+	"SyncPolicy",
+	"WhitelistSets",
+	"DryRun",
+	"LeaderElect",
+	"LeaderElectLockType",
+	"LeaderElectNamespace",
+	"LeaderElectLeaseDuration",
+	"LeaderElectRenewDeadline",
+	"LeaderElectRetryPeriod",
+	"GCPeriod",
+}
+
+// Watcher reloads the config file when it is changed. The file is polled instead of watched by
+// inotify, since the ConfigMap volume is updated by swapping the symlink of directory.
+type Watcher struct {
+	path     string
+	base     *Config
+	interval time.Duration
+
+	mux      sync.RWMutex
+	current  *Config
+	checksum [sha256.Size]byte
+	handlers []func(old, new *Config)
+}
+
+// NewWatcher creates an instance of the config watcher, and loads the config file on top of the base config
+func NewWatcher(path string, base *Config, interval time.Duration) (*Watcher, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := Parse(data, base)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Watcher{
+		path:     path,
+		base:     base.DeepCopy(),
+		interval: interval,
+		current:  cfg,
+		checksum: sha256.Sum256(data),
+	}, nil
+}
+
+// Get returns the last good config
+func (w *Watcher) Get() *Config {
+	w.mux.RLock()
+	defer w.mux.RUnlock()
+	return w.current
+}
+
+// OnChange adds the handler which is called after the config is reloaded
+func (w *Watcher) OnChange(handler func(old, new *Config)) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	w.handlers = append(w.handlers, handler)
+}
+
+// Run polls the config file until the context is done
+func (w *Watcher) Run(ctx context.Context) {
+	glog.Infof("Watching the config file '%s'", w.path)
+	wait.Until(w.reload, w.interval, ctx.Done())
+}
+
+func (w *Watcher) reload() {
+	data, err := ioutil.ReadFile(w.path)
+	if err != nil {
+		glog.Errorf("Failed to read the config file '%s', keeping the last good config: %s", w.path, err.Error())
+		return
+	}
+
+	checksum := sha256.Sum256(data)
+	w.mux.Lock()
+	if checksum == w.checksum {
+		w.mux.Unlock()
+		return
+	}
+
+	// Records the checksum even if the config is invalid, so that the same error isn't reported repeatedly
+	w.checksum = checksum
+	cfg, err := Parse(data, w.base)
+	if err != nil {
+		w.mux.Unlock()
+		glog.Errorf("Rejected the config file '%s', keeping the last good config: %s", w.path, err.Error())
+		return
+	}
+
+	old := w.current
+	w.current = cfg
+	handlers := append([]func(old, new *Config){}, w.handlers...)
+	w.mux.Unlock()
+
+	if changed := changedFields(old, cfg, restartFields); len(changed) > 0 {
+		glog.Warningf("The changes of %s take effect after restart", strings.Join(changed, ", "))
+	}
+
+	glog.Infof("Reloaded the config file '%s'", w.path)
+	for _, handler := range handlers {
+		handler(old, cfg)
+	}
+}
+
+func changedFields(old, new *Config, fields []string) []string {
+	changed := []string{}
+	ov, nv := reflect.ValueOf(old).Elem(), reflect.ValueOf(new).Elem()
+	for _, field := range fields {
+		if !reflect.DeepEqual(ov.FieldByName(field).Interface(), nv.FieldByName(field).Interface()) {
+			changed = append(changed, field)
+		}
+	}
+	return changed
+}
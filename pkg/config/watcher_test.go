@@ -0,0 +1,74 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const timeout = time.Second * 3
+
+func TestWatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.yml")
+	assert.Nil(t, ioutil.WriteFile(path, []byte("logSetting: log1\n"), 0644))
+
+	// The invalid config file is rejected on startup
+	_, err = NewWatcher(filepath.Join(dir, "none.yml"), newBaseConfig(), time.Millisecond*10)
+	assert.NotNil(t, err)
+
+	watcher, err := NewWatcher(path, newBaseConfig(), time.Millisecond*10)
+	assert.Nil(t, err)
+	assert.Equal(t, "log1", watcher.Get().LogSettingName)
+
+	changed := make(chan string, 10)
+	watcher.OnChange(func(old, new *Config) {
+		changed <- new.LogSettingName
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go watcher.Run(ctx)
+
+	assert.Nil(t, ioutil.WriteFile(path, []byte("logSetting: log2\n"), 0644))
+	select {
+	case name := <-changed:
+		assert.Equal(t, "log2", name)
+	case <-time.After(timeout):
+		t.Fatal("failed to reload the config file.")
+	}
+
+	// The invalid config is rejected, and the last good config is kept
+	assert.Nil(t, ioutil.WriteFile(path, []byte("threads: 0\n"), 0644))
+	select {
+	case <-changed:
+		t.Fatal("the invalid config file was reloaded.")
+	case <-time.After(time.Millisecond * 100):
+	}
+	assert.Equal(t, "log2", watcher.Get().LogSettingName)
+	assert.Equal(t, 2, watcher.Get().Threads)
+}
@@ -33,12 +33,77 @@ package constants
 
 const PolicyPrefix = "k8s"
 
+// ServiceFinalizer is the finalizer for cleaning up the NAT and Security of Kubernetes service
+const ServiceFinalizer = "inwinstack.com/pa-svc-syncker"
+
 // Annotation Keys
 const (
 	// PublicIPKey is the key of annotation for recording IP
 	PublicIPKey = "inwinstack.com/allocated-public-ip"
+	// SyncedPublicIPKey is the key of annotation for recording the public IP which has been synced
+	SyncedPublicIPKey = "inwinstack.com/synced-public-ip"
+	// SyncStatusKey is the key of annotation for recording the sync status of Kubernetes service
+	SyncStatusKey = "inwinstack.com/pa-sync-status"
 	// ServiceRefreshKey is the key of annotation for refreshing Kubernetes service object
 	ServiceRefreshKey = "inwinstack.com/service-refresh"
 	// WhiteListAddressesKey is the key of annotations for the whitelist
 	WhiteListAddressesKey = "inwinstack.com/whitelist-addresses"
+	// WhitelistSetsKey is the key of annotation for the comma-separated WhitelistSets merged into the whitelist
+	WhitelistSetsKey = "inwinstack.com/whitelist-sets"
+)
+
+// Security Annotation Keys, which override the default security policy
+const (
+	// SecuritySourceZonesKey is the key of annotation for overriding the source zones
+	SecuritySourceZonesKey = "inwinstack.com/security-source-zones"
+	// SecurityDestinationZonesKey is the key of annotation for overriding the destination zones
+	SecurityDestinationZonesKey = "inwinstack.com/security-destination-zones"
+	// SecuritySourceUsersKey is the key of annotation for overriding the source users
+	SecuritySourceUsersKey = "inwinstack.com/security-source-users"
+	// SecurityHipProfilesKey is the key of annotation for overriding the hip profiles
+	SecurityHipProfilesKey = "inwinstack.com/security-hip-profiles"
+	// SecurityApplicationsKey is the key of annotation for overriding the applications
+	SecurityApplicationsKey = "inwinstack.com/security-applications"
+	// SecurityCategoriesKey is the key of annotation for overriding the categories
+	SecurityCategoriesKey = "inwinstack.com/security-categories"
+	// SecurityActionKey is the key of Service annotation for overriding the action
+	SecurityActionKey = "inwinstack.com/security-action"
+	// SecurityLogSettingKey is the key of annotation for overriding the log-setting name
+	SecurityLogSettingKey = "inwinstack.com/security-log-setting"
+	// SecurityGroupKey is the key of annotation for overriding the group name
+	SecurityGroupKey = "inwinstack.com/security-group"
+)
+
+// Label Keys
+const (
+	// ManagedByKey is the key of label for recording the manager of objects
+	ManagedByKey = "inwinstack.com/managed-by"
+	// ManagedByValue is the value of ManagedByKey label for the objects which are created by syncker
+	ManagedByValue = "pa-svc-syncker"
+	// ServiceNamespaceKey is the key of label for recording the namespace of source service
+	ServiceNamespaceKey = "inwinstack.com/service-namespace"
+	// ServiceNameKey is the key of label for recording the name of source service
+	ServiceNameKey = "inwinstack.com/service-name"
+	// PublicIPLabelKey is the key of label for recording the public IP
+	PublicIPLabelKey = "inwinstack.com/public-ip"
+	// SyncOptInKey is the default key of Service label or annotation for opting in or out of syncing
+	SyncOptInKey = "inwinstack.com/pa-sync"
+)
+
+// Event Reasons
+const (
+	// NATCreatedReason is the reason of event when the NAT was created
+	NATCreatedReason = "NATCreated"
+	// NATUpdatedReason is the reason of event when the drifted NAT was updated
+	NATUpdatedReason = "NATUpdated"
+	// SecurityCreatedReason is the reason of event when the Security was created
+	SecurityCreatedReason = "SecurityCreated"
+	// SecurityUpdatedReason is the reason of event when the drifted Security was updated
+	SecurityUpdatedReason = "SecurityUpdated"
+	// SyncFailedReason is the reason of event when failed to sync
+	SyncFailedReason = "SyncFailed"
+	// ExternalIPConflictReason is the reason of event when the Services sharing a public IP pair it with different external IPs
+	ExternalIPConflictReason = "ExternalIPConflict"
+	// UnsupportedProtocolReason is the reason of event when the ports of unsupported protocol were skipped
+	UnsupportedProtocolReason = "UnsupportedProtocol"
 )
@@ -0,0 +1,95 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package health
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Checker checks the readiness and liveness of a component
+type Checker interface {
+	Ready() bool
+	Healthy() error
+}
+
+// WorkerProbe records the informer sync and worker progress of a controller
+type WorkerProbe struct {
+	ready         int32
+	lastProcessed int64
+}
+
+// NewWorkerProbe creates an instance of the worker probe
+func NewWorkerProbe() *WorkerProbe {
+	return &WorkerProbe{lastProcessed: time.Now().UnixNano()}
+}
+
+// SetReady marks the informer caches have been synced
+func (p *WorkerProbe) SetReady() {
+	atomic.StoreInt32(&p.ready, 1)
+}
+
+// Ready returns true when the informer caches have been synced
+func (p *WorkerProbe) Ready() bool {
+	return atomic.LoadInt32(&p.ready) == 1
+}
+
+// Processed records that a worker has processed an item
+func (p *WorkerProbe) Processed() {
+	atomic.StoreInt64(&p.lastProcessed, time.Now().UnixNano())
+}
+
+// Healthy returns an error if there are items in queue, but no item has been processed in the window
+func (p *WorkerProbe) Healthy(queueLen int, window time.Duration) error {
+	if queueLen == 0 {
+		return nil
+	}
+
+	last := time.Unix(0, atomic.LoadInt64(&p.lastProcessed))
+	if since := time.Since(last); since > window {
+		return fmt.Errorf("workers haven't processed any item for %s with %d items in queue", since.Round(time.Second), queueLen)
+	}
+	return nil
+}
+
+// ReadyzHandler returns the handler which reports ready when all checkers are ready
+func ReadyzHandler(checkers ...Checker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for _, checker := range checkers {
+			if !checker.Ready() {
+				http.Error(w, "not ready", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		fmt.Fprint(w, "ok")
+	}
+}
+
+// HealthzHandler returns the handler which reports healthy when all checkers are healthy
+func HealthzHandler(checkers ...Checker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for _, checker := range checkers {
+			if err := checker.Healthy(); err != nil {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+		}
+		fmt.Fprint(w, "ok")
+	}
+}
@@ -0,0 +1,130 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package health
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/inwinstack/pa-svc-syncker/pkg/config"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestWorkerProbe(t *testing.T) {
+	probe := NewWorkerProbe()
+	assert.False(t, probe.Ready())
+	probe.SetReady()
+	assert.True(t, probe.Ready())
+
+	assert.Nil(t, probe.Healthy(0, time.Millisecond))
+	time.Sleep(5 * time.Millisecond)
+	assert.NotNil(t, probe.Healthy(1, time.Millisecond))
+
+	probe.Processed()
+	assert.Nil(t, probe.Healthy(1, time.Second))
+}
+
+func TestClientProbe(t *testing.T) {
+	failed := false
+	cfg := &config.Config{HealthCheckWindow: metav1.Duration{Duration: time.Millisecond}}
+	probe := NewClientProbe(cfg)
+	rt := probe.Wrap(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if failed {
+			return nil, fmt.Errorf("connection refused")
+		}
+		return &http.Response{StatusCode: http.StatusOK}, nil
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/apis/inwinstack.com/v1/nats", nil)
+
+	_, err := rt.RoundTrip(req)
+	assert.Nil(t, err)
+	assert.Nil(t, probe.Healthy())
+
+	failed = true
+	time.Sleep(5 * time.Millisecond)
+	_, err = rt.RoundTrip(req)
+	assert.NotNil(t, err)
+	assert.NotNil(t, probe.Healthy())
+
+	// The window of reloaded config takes effect on the next check
+	cfg.HealthCheckWindow.Duration = time.Minute
+	assert.Nil(t, probe.Healthy())
+
+	failed = false
+	_, err = rt.RoundTrip(req)
+	assert.Nil(t, err)
+	assert.Nil(t, probe.Healthy())
+}
+
+type fakeChecker struct {
+	ready bool
+	err   error
+}
+
+func (c *fakeChecker) Ready() bool    { return c.ready }
+func (c *fakeChecker) Healthy() error { return c.err }
+
+func TestHandlers(t *testing.T) {
+	checker := &fakeChecker{}
+
+	rec := httptest.NewRecorder()
+	ReadyzHandler(checker)(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	checker.ready = true
+	rec = httptest.NewRecorder()
+	ReadyzHandler(checker)(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = httptest.NewRecorder()
+	HealthzHandler(checker)(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	checker.err = fmt.Errorf("unhealthy")
+	rec = httptest.NewRecorder()
+	HealthzHandler(checker)(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
+
+func TestLeaderProbe(t *testing.T) {
+	checker := &fakeChecker{ready: false, err: fmt.Errorf("workers are stuck")}
+	probe := NewLeaderProbe(checker)
+
+	// The standby is ready while waiting for the leadership
+	assert.False(t, probe.Leading())
+	assert.True(t, probe.Ready())
+	assert.Nil(t, probe.Healthy())
+
+	probe.SetLeading()
+	assert.True(t, probe.Leading())
+	assert.False(t, probe.Ready())
+	assert.NotNil(t, probe.Healthy())
+
+	checker.ready, checker.err = true, nil
+	assert.True(t, probe.Ready())
+	assert.Nil(t, probe.Healthy())
+}
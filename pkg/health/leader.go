@@ -0,0 +1,59 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package health
+
+import (
+	"sync/atomic"
+)
+
+// LeaderProbe wraps the checker of controllers which only run on the leader. The standby is ready
+// and healthy while it is waiting for the leadership, and the checker is used after it leads.
+type LeaderProbe struct {
+	checker Checker
+	leading int32
+}
+
+// NewLeaderProbe creates an instance of the leader probe
+func NewLeaderProbe(checker Checker) *LeaderProbe {
+	return &LeaderProbe{checker: checker}
+}
+
+// SetLeading marks the leadership has been acquired
+func (p *LeaderProbe) SetLeading() {
+	atomic.StoreInt32(&p.leading, 1)
+}
+
+// Leading returns true when the leadership has been acquired
+func (p *LeaderProbe) Leading() bool {
+	return atomic.LoadInt32(&p.leading) == 1
+}
+
+// Ready returns true for the standby, or the readiness of checker for the leader
+func (p *LeaderProbe) Ready() bool {
+	if !p.Leading() {
+		return true
+	}
+	return p.checker.Ready()
+}
+
+// Healthy returns nil for the standby, or the health of checker for the leader
+func (p *LeaderProbe) Healthy() error {
+	if !p.Leading() {
+		return nil
+	}
+	return p.checker.Healthy()
+}
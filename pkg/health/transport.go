@@ -0,0 +1,84 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package health
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/inwinstack/pa-svc-syncker/pkg/config"
+)
+
+// DefaultWindow is the window of probes when the health check window isn't configured
+const DefaultWindow = time.Minute * 3
+
+// ClientProbe records the results of client requests, and reports unhealthy
+// when the requests have been failing for the window. The window is read from
+// the config on every check, so the reloaded config takes effect without restarting.
+type ClientProbe struct {
+	cfg         config.Getter
+	lastSuccess int64
+	lastFailure int64
+}
+
+// NewClientProbe creates an instance of the client probe
+func NewClientProbe(cfg config.Getter) *ClientProbe {
+	return &ClientProbe{cfg: cfg, lastSuccess: time.Now().UnixNano()}
+}
+
+// Wrap wraps the round tripper of client to record the results of requests
+func (p *ClientProbe) Wrap(rt http.RoundTripper) http.RoundTripper {
+	return &probeTransport{rt: rt, probe: p}
+}
+
+// Ready always returns true, because the client has no cache to sync
+func (p *ClientProbe) Ready() bool {
+	return true
+}
+
+// Healthy returns an error if no request has succeeded for the window since the last failure
+func (p *ClientProbe) Healthy() error {
+	window := DefaultWindow
+	if w := p.cfg.Get().HealthCheckWindow.Duration; w > 0 {
+		window = w
+	}
+
+	lastSuccess := time.Unix(0, atomic.LoadInt64(&p.lastSuccess))
+	lastFailure := time.Unix(0, atomic.LoadInt64(&p.lastFailure))
+	if lastFailure.After(lastSuccess) && lastFailure.Sub(lastSuccess) > window {
+		return fmt.Errorf("client requests have been failing since %s", lastSuccess.Format(time.RFC3339))
+	}
+	return nil
+}
+
+type probeTransport struct {
+	rt    http.RoundTripper
+	probe *ClientProbe
+}
+
+func (t *probeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.rt.RoundTrip(req)
+	now := time.Now().UnixNano()
+	if err != nil || resp.StatusCode >= http.StatusInternalServerError {
+		atomic.StoreInt64(&t.probe.lastFailure, now)
+	} else {
+		atomic.StoreInt64(&t.probe.lastSuccess, now)
+	}
+	return resp, err
+}
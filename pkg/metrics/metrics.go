@@ -0,0 +1,114 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "pa_svc_syncker"
+
+// These are the valid results of reconcile.
+const (
+	ResultSuccess = "success"
+	ResultError   = "error"
+)
+
+// These are the valid results of updating an existing object.
+const (
+	UpdateSkipped = "skipped"
+	UpdateWritten = "written"
+
+	// ResultResynced is the result of namespace update which re-syncs the services
+	ResultResynced = "resynced"
+)
+
+var (
+	// ReconcileTotal counts the reconciles by controller and result
+	ReconcileTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reconcile_total",
+		Help:      "Total number of reconciles by controller and result.",
+	}, []string{"controller", "result"})
+
+	// ReconcileDuration observes the reconcile durations by controller and result
+	ReconcileDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "reconcile_duration_seconds",
+		Help:      "Duration in seconds of reconciles by controller and result.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 15),
+	}, []string{"controller", "result"})
+
+	// BlendedErrors counts the failed requests of blended API by resource, method and code
+	BlendedErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "blended_api_errors_total",
+		Help:      "Total number of failed requests to the blended API by resource, method and code.",
+	}, []string{"resource", "method", "code"})
+
+	// OrphanedObjects counts the orphaned objects which were found by the last garbage collection
+	OrphanedObjects = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "orphaned_objects",
+		Help:      "Number of orphaned objects found by the last garbage collection per namespace and kind.",
+	}, []string{"namespace", "kind"})
+
+	// CollectedObjects counts the orphaned objects which were deleted by kind
+	CollectedObjects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "collected_objects_total",
+		Help:      "Total number of orphaned objects deleted by the garbage collection per kind.",
+	}, []string{"kind"})
+
+	// ObjectUpdates counts the updates of the existing objects by kind and result, the update is skipped
+	// if the object is up to date
+	ObjectUpdates = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "object_updates_total",
+		Help:      "Total number of skipped and written updates of the existing objects by kind.",
+	}, []string{"kind", "result"})
+
+	// NamespaceUpdates counts the namespace updates by result, the update is skipped if the whitelist
+	// and security annotations are unchanged
+	NamespaceUpdates = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "namespace_updates_total",
+		Help:      "Total number of skipped and resynced namespace updates.",
+	}, []string{"result"})
+)
+
+func init() {
+	prometheus.MustRegister(ReconcileTotal)
+	prometheus.MustRegister(ReconcileDuration)
+	prometheus.MustRegister(BlendedErrors)
+	prometheus.MustRegister(OrphanedObjects)
+	prometheus.MustRegister(CollectedObjects)
+	prometheus.MustRegister(ObjectUpdates)
+	prometheus.MustRegister(NamespaceUpdates)
+}
+
+// ObserveReconcile records the result and duration of a reconcile
+func ObserveReconcile(controller string, start time.Time, err error) {
+	result := ResultSuccess
+	if err != nil {
+		result = ResultError
+	}
+	ReconcileTotal.WithLabelValues(controller, result).Inc()
+	ReconcileDuration.WithLabelValues(controller, result).Observe(time.Since(start).Seconds())
+}
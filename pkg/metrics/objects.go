@@ -0,0 +1,92 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"github.com/golang/glog"
+	blendedinformerv1 "github.com/inwinstack/blended/generated/informers/externalversions/inwinstack/v1"
+	"github.com/inwinstack/pa-svc-syncker/pkg/constants"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+var managedObjectsDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "", "managed_objects"),
+	"Number of NAT and Security objects managed by syncker per namespace.",
+	[]string{"namespace", "kind"}, nil,
+)
+
+// objectsCollector collects the number of managed objects from the informer caches when scraping
+type objectsCollector struct {
+	nats blendedinformerv1.NATInformer
+	secs blendedinformerv1.SecurityInformer
+}
+
+// RegisterObjectsCollector registers the collector of managed NAT and Security objects, which are counted
+// from the informer caches of controllers instead of listing them from the API server on every scrape.
+func RegisterObjectsCollector(nats blendedinformerv1.NATInformer, secs blendedinformerv1.SecurityInformer) error {
+	return prometheus.Register(newObjectsCollector(nats, secs))
+}
+
+func newObjectsCollector(nats blendedinformerv1.NATInformer, secs blendedinformerv1.SecurityInformer) *objectsCollector {
+	// Gets the informers before the factory is started, so that they are shared with the controllers
+	nats.Informer()
+	secs.Informer()
+	return &objectsCollector{nats: nats, secs: secs}
+}
+
+func (c *objectsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- managedObjectsDesc
+}
+
+// Collect skips the objects until the caches have been synced, e.g. the informers are not started
+// before the syncker becomes the leader.
+func (c *objectsCollector) Collect(ch chan<- prometheus.Metric) {
+	selector := labels.SelectorFromSet(labels.Set{constants.ManagedByKey: constants.ManagedByValue})
+
+	if c.nats.Informer().HasSynced() {
+		nats, err := c.nats.Lister().List(selector)
+		if err != nil {
+			glog.Errorf("Failed to list NATs for metrics: %s", err.Error())
+		} else {
+			counts := map[string]int{}
+			for _, nat := range nats {
+				counts[nat.Namespace]++
+			}
+			collectCounts(ch, "NAT", counts)
+		}
+	}
+
+	if c.secs.Informer().HasSynced() {
+		secs, err := c.secs.Lister().List(selector)
+		if err != nil {
+			glog.Errorf("Failed to list Securities for metrics: %s", err.Error())
+		} else {
+			counts := map[string]int{}
+			for _, sec := range secs {
+				counts[sec.Namespace]++
+			}
+			collectCounts(ch, "Security", counts)
+		}
+	}
+}
+
+func collectCounts(ch chan<- prometheus.Metric, kind string, counts map[string]int) {
+	for ns, count := range counts {
+		ch <- prometheus.MustNewConstMetric(managedObjectsDesc, prometheus.GaugeValue, float64(count), ns, kind)
+	}
+}
@@ -0,0 +1,73 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"context"
+	"testing"
+
+	blendedv1 "github.com/inwinstack/blended/apis/inwinstack/v1"
+	blendedfake "github.com/inwinstack/blended/generated/clientset/versioned/fake"
+	blendedinformers "github.com/inwinstack/blended/generated/informers/externalversions"
+	"github.com/inwinstack/pa-svc-syncker/pkg/constants"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestObjectsCollector(t *testing.T) {
+	managed := map[string]string{constants.ManagedByKey: constants.ManagedByValue}
+	blendedset := blendedfake.NewSimpleClientset(
+		&blendedv1.NAT{ObjectMeta: metav1.ObjectMeta{Name: "k8s-140.11.22.33", Namespace: "test", Labels: managed}},
+		&blendedv1.Security{ObjectMeta: metav1.ObjectMeta{Name: "k8s-140.11.22.33", Namespace: "test", Labels: managed}},
+		&blendedv1.Security{ObjectMeta: metav1.ObjectMeta{Name: "hand-written", Namespace: "test"}},
+	)
+	factory := blendedinformers.NewSharedInformerFactory(blendedset, 0)
+	collector := newObjectsCollector(factory.Inwinstack().V1().NATs(), factory.Inwinstack().V1().Securities())
+
+	// Nothing is counted before the informers are started
+	assert.Empty(t, collectValues(collector))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	factory.Start(ctx.Done())
+	cache.WaitForCacheSync(ctx.Done(), factory.Inwinstack().V1().NATs().Informer().HasSynced, factory.Inwinstack().V1().Securities().Informer().HasSynced)
+
+	blendedset.ClearActions()
+	assert.Equal(t, map[string]float64{"test/NAT": 1, "test/Security": 1}, collectValues(collector))
+	assert.Empty(t, blendedset.Actions())
+}
+
+func collectValues(collector prometheus.Collector) map[string]float64 {
+	ch := make(chan prometheus.Metric, 10)
+	collector.Collect(ch)
+	close(ch)
+
+	values := map[string]float64{}
+	for metric := range ch {
+		m := &dto.Metric{}
+		metric.Write(m)
+		labels := map[string]string{}
+		for _, pair := range m.Label {
+			labels[pair.GetName()] = pair.GetValue()
+		}
+		values[labels["namespace"]+"/"+labels["kind"]] = m.GetGauge().GetValue()
+	}
+	return values
+}
@@ -0,0 +1,63 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// blendedTransport counts the failed requests of blended API
+type blendedTransport struct {
+	rt http.RoundTripper
+}
+
+// WrapBlendedTransport wraps the round tripper of blended client to count the failed requests.
+// The NotFound responses are not counted, because the controllers use them to check the existence of objects.
+func WrapBlendedTransport(rt http.RoundTripper) http.RoundTripper {
+	return &blendedTransport{rt: rt}
+}
+
+func (t *blendedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.rt.RoundTrip(req)
+	resource := parseResource(req.URL.Path)
+	if err != nil {
+		BlendedErrors.WithLabelValues(resource, req.Method, "").Inc()
+		return resp, err
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest && resp.StatusCode != http.StatusNotFound {
+		BlendedErrors.WithLabelValues(resource, req.Method, strconv.Itoa(resp.StatusCode)).Inc()
+	}
+	return resp, err
+}
+
+// parseResource parses the resource from the API path, e.g. "/apis/inwinstack.com/v1/namespaces/test/nats/k8s-1.1.1.1" is "nats"
+func parseResource(path string) string {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	// The path is /apis/<group>/<version>/...
+	if len(parts) < 4 || parts[0] != "apis" {
+		return ""
+	}
+
+	parts = parts[3:]
+	if parts[0] == "namespaces" && len(parts) > 2 {
+		return parts[2]
+	}
+	return parts[0]
+}
@@ -0,0 +1,41 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseResource(t *testing.T) {
+	tests := []struct {
+		Path     string
+		Resource string
+	}{
+		{Path: "/apis/inwinstack.com/v1/namespaces/test/nats/k8s-140.11.22.33", Resource: "nats"},
+		{Path: "/apis/inwinstack.com/v1/namespaces/test/securities", Resource: "securities"},
+		{Path: "/apis/inwinstack.com/v1/securities", Resource: "securities"},
+		{Path: "/apis/inwinstack.com/v1/services/k8s-140.11.22.33-tcp", Resource: "services"},
+		{Path: "/apis/inwinstack.com/v1/namespaces", Resource: "namespaces"},
+		{Path: "/api/v1/namespaces/test/services", Resource: ""},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.Resource, parseResource(test.Path))
+	}
+}
@@ -19,55 +19,98 @@ package namespace
 import (
 	"context"
 	"fmt"
+	"reflect"
 	"time"
 
 	"github.com/golang/glog"
-	blended "github.com/inwinstack/blended/generated/clientset/versioned"
 	"github.com/inwinstack/pa-svc-syncker/pkg/config"
+	"github.com/inwinstack/pa-svc-syncker/pkg/health"
+	"github.com/inwinstack/pa-svc-syncker/pkg/metrics"
 	"github.com/inwinstack/pa-svc-syncker/pkg/operator/service"
-	"github.com/thoas/go-funk"
+	"github.com/inwinstack/pa-svc-syncker/pkg/whitelistset"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
 	informerv1 "k8s.io/client-go/informers/core/v1"
-	"k8s.io/client-go/kubernetes"
 	listerv1 "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/util/workqueue"
 )
 
+const (
+	namespaceQueueName = "Namespaces"
+
+	// whitelistSetIndex indexes the namespaces by the names of referenced WhitelistSets
+	whitelistSetIndex = "whitelistSet"
+)
+
+// ServiceEnqueuer enqueues the services of namespace, so that their Securities are rendered by the
+// service controller
+type ServiceEnqueuer interface {
+	EnqueueServices(namespace string)
+}
+
 // Controller represents the controller of namespace
 type Controller struct {
-	cfg *config.Config
+	cfg config.Getter
 
-	clientset  kubernetes.Interface
-	blendedset blended.Interface
 	lister     listerv1.NamespaceLister
+	indexer    cache.Indexer
 	synced     cache.InformerSynced
+	whitelists *service.WhitelistCache
+	sets       *whitelistset.Store
+	services   ServiceEnqueuer
 	queue      workqueue.RateLimitingInterface
+	probe      *health.WorkerProbe
 }
 
 // NewController creates an instance of the namespace controller
 func NewController(
-	cfg *config.Config,
-	clientset kubernetes.Interface,
-	blendedset blended.Interface,
-	informer informerv1.NamespaceInformer) *Controller {
+	cfg config.Getter,
+	informer informerv1.NamespaceInformer,
+	whitelists *service.WhitelistCache,
+	sets *whitelistset.Store,
+	services ServiceEnqueuer) *Controller {
 	controller := &Controller{
 		cfg:        cfg,
-		clientset:  clientset,
-		blendedset: blendedset,
 		lister:     informer.Lister(),
+		indexer:    informer.Informer().GetIndexer(),
 		synced:     informer.Informer().HasSynced,
-		queue:      workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "Namespaces"),
+		whitelists: whitelists,
+		sets:       sets,
+		services:   services,
+		queue:      workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), namespaceQueueName),
+		probe:      health.NewWorkerProbe(),
 	}
 	informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
-		AddFunc: controller.enqueue,
 		UpdateFunc: func(old, new interface{}) {
+			// The periodic resyncs and the changes of other fields don't affect the Securities
+			oldNs, newNs := old.(*v1.Namespace), new.(*v1.Namespace)
+			if !service.SecurityAnnotationsChanged(oldNs, newNs) {
+				glog.V(4).Infof("Namespace controller skipped '%s' whose security annotations are unchanged", newNs.Name)
+				metrics.NamespaceUpdates.WithLabelValues(metrics.UpdateSkipped).Inc()
+				return
+			}
 			controller.enqueue(new)
 		},
 	})
+
+	if err := informer.Informer().AddIndexers(cache.Indexers{whitelistSetIndex: whitelistSetIndexFunc}); err != nil {
+		utilruntime.HandleError(fmt.Errorf("failed to add the WhitelistSet index: %s", err.Error()))
+	}
+	sets.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: controller.enqueueWhitelistSet,
+		UpdateFunc: func(old, new interface{}) {
+			oldSet, oldErr := whitelistset.Convert(old)
+			newSet, newErr := whitelistset.Convert(new)
+			if oldErr == nil && newErr == nil && reflect.DeepEqual(oldSet.Spec, newSet.Spec) {
+				return
+			}
+			controller.enqueueWhitelistSet(new)
+		},
+		DeleteFunc: controller.enqueueWhitelistSet,
+	})
 	return controller
 }
 
@@ -75,15 +118,29 @@ func NewController(
 func (c *Controller) Run(ctx context.Context, threadiness int) error {
 	glog.Info("Starting Namespace controller")
 	glog.Info("Waiting for Namespace informer caches to sync")
-	if ok := cache.WaitForCacheSync(ctx.Done(), c.synced); !ok {
+	if ok := cache.WaitForCacheSync(ctx.Done(), c.synced, c.sets.HasSynced); !ok {
 		return fmt.Errorf("failed to wait for caches to sync")
 	}
+	c.probe.SetReady()
 	for i := 0; i < threadiness; i++ {
 		go wait.Until(c.runWorker, time.Second, ctx.Done())
 	}
 	return nil
 }
 
+// Ready returns true when the Namespace informer caches have been synced
+func (c *Controller) Ready() bool {
+	return c.probe.Ready()
+}
+
+// Healthy returns an error if the workers haven't processed any item in the window
+func (c *Controller) Healthy(window time.Duration) error {
+	if err := c.probe.Healthy(c.queue.Len(), window); err != nil {
+		return fmt.Errorf("Namespace controller is unhealthy: %s", err.Error())
+	}
+	return nil
+}
+
 // Stop stops the namespace controller
 func (c *Controller) Stop() {
 	glog.Info("Stopping the Namespace controller")
@@ -111,7 +168,11 @@ func (c *Controller) processNextWorkItem() bool {
 			return nil
 		}
 
-		if err := c.reconcile(key); err != nil {
+		start := time.Now()
+		err := c.reconcile(key)
+		metrics.ObserveReconcile(namespaceQueueName, start, err)
+		c.probe.Processed()
+		if err != nil {
 			c.queue.AddRateLimited(key)
 			return fmt.Errorf("Namespace controller error syncing '%s': %s, requeuing", key, err.Error())
 		}
@@ -130,8 +191,8 @@ func (c *Controller) processNextWorkItem() bool {
 
 func (c *Controller) enqueue(obj interface{}) {
 	ns := obj.(*v1.Namespace).DeepCopy()
-	if funk.Contains(c.cfg.IgnoreNamespaces, ns.Name) {
-		glog.V(3).Infof("Namespace controller ignored '%s'", ns.Name)
+	if !c.cfg.Get().SelectsNamespace(ns) {
+		glog.V(3).Infof("Namespace controller ignored '%s' which is not selected", ns.Name)
 		return
 	}
 
@@ -143,6 +204,34 @@ func (c *Controller) enqueue(obj interface{}) {
 	c.queue.Add(key)
 }
 
+// enqueueWhitelistSet enqueues the namespaces referencing the WhitelistSet
+func (c *Controller) enqueueWhitelistSet(obj interface{}) {
+	set, err := whitelistset.Convert(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+
+	objs, err := c.indexer.ByIndex(whitelistSetIndex, set.Name)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+
+	for _, obj := range objs {
+		glog.V(3).Infof("Namespace controller enqueuing '%s' for the changed WhitelistSet '%s'", obj.(*v1.Namespace).Name, set.Name)
+		c.enqueue(obj)
+	}
+}
+
+func whitelistSetIndexFunc(obj interface{}) ([]string, error) {
+	ns, ok := obj.(*v1.Namespace)
+	if !ok {
+		return []string{}, nil
+	}
+	return service.WhitelistSetNames(ns), nil
+}
+
 func (c *Controller) reconcile(key string) error {
 	_, name, err := cache.SplitMetaNamespaceKey(key)
 	if err != nil {
@@ -150,7 +239,8 @@ func (c *Controller) reconcile(key string) error {
 		return err
 	}
 
-	if _, err := c.lister.Get(name); err != nil {
+	ns, err := c.lister.Get(name)
+	if err != nil {
 		if errors.IsNotFound(err) {
 			utilruntime.HandleError(fmt.Errorf("namespace '%s' in work queue no longer exists", key))
 			return err
@@ -158,13 +248,13 @@ func (c *Controller) reconcile(key string) error {
 		return err
 	}
 
-	sourceAddresses, err := service.ParseAddresses(c.clientset, name)
-	if err != nil {
+	// Validates the namespace annotations before re-rendering any Security
+	if _, err := service.NamespaceSecurityPolicy(service.NewSecurityPolicy(c.cfg.Get(), nil), c.whitelists, ns); err != nil {
 		return err
 	}
 
-	if err := c.updateSecurity(name, sourceAddresses); err != nil {
-		return err
-	}
+	glog.V(2).Infof("Namespace controller enqueuing the services of '%s'", ns.Name)
+	metrics.NamespaceUpdates.WithLabelValues(metrics.ResultResynced).Inc()
+	c.services.EnqueueServices(ns.Name)
 	return nil
 }
@@ -18,23 +18,47 @@ package namespace
 
 import (
 	"context"
-	"reflect"
+	"sync"
 	"testing"
 	"time"
 
-	blendedv1 "github.com/inwinstack/blended/apis/inwinstack/v1"
-	blendedfake "github.com/inwinstack/blended/generated/clientset/versioned/fake"
 	"github.com/inwinstack/pa-svc-syncker/pkg/config"
 	"github.com/inwinstack/pa-svc-syncker/pkg/constants"
+	"github.com/inwinstack/pa-svc-syncker/pkg/metrics"
+	"github.com/inwinstack/pa-svc-syncker/pkg/operator/service"
+	"github.com/inwinstack/pa-svc-syncker/pkg/whitelistset"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes/fake"
 )
 
 const timeout = time.Second * 3
 
+// fakeEnqueuer records the namespaces whose services were enqueued
+type fakeEnqueuer struct {
+	mu         sync.Mutex
+	namespaces []string
+}
+
+func (f *fakeEnqueuer) EnqueueServices(namespace string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.namespaces = append(f.namespaces, namespace)
+}
+
+func (f *fakeEnqueuer) enqueued() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string{}, f.namespaces...)
+}
+
 func TestNamespaceController(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	cfg := &config.Config{
@@ -42,10 +66,10 @@ func TestNamespaceController(t *testing.T) {
 	}
 
 	clientset := fake.NewSimpleClientset()
-	blendedset := blendedfake.NewSimpleClientset()
 	informer := informers.NewSharedInformerFactory(clientset, 0)
+	services := &fakeEnqueuer{}
 
-	controller := NewController(cfg, clientset, blendedset, informer.Core().V1().Namespaces())
+	controller := NewController(cfg, informer.Core().V1().Namespaces(), nil, nil, services)
 	go informer.Start(ctx.Done())
 	assert.Nil(t, controller.Run(ctx, cfg.Threads))
 
@@ -57,47 +81,97 @@ func TestNamespaceController(t *testing.T) {
 	_, err := clientset.CoreV1().Namespaces().Create(ns)
 	assert.Nil(t, err)
 
-	sec := &blendedv1.Security{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      "test-sec",
-			Namespace: ns.Name,
-		},
-		Spec: blendedv1.SecuritySpec{
-			SourceZones:          []string{"untrust"},
-			SourceAddresses:      []string{"any"},
-			SourceUsers:          []string{"any"},
-			HipProfiles:          []string{"any"},
-			DestinationZones:     []string{"test-zone"},
-			DestinationAddresses: []string{"140.23.110.10"},
-			Applications:         []string{"any"},
-			Categories:           []string{"any"},
-			Services:             []string{"k8s-tcp80"},
-			Action:               blendedv1.SecurityAllow,
-		},
-	}
-	createSec, err := blendedset.InwinstackV1().Securities(ns.Name).Create(sec)
+	// The changes of other annotations don't enqueue the services
+	skipped := testutil.ToFloat64(metrics.NamespaceUpdates.WithLabelValues(metrics.UpdateSkipped))
+	resynced := testutil.ToFloat64(metrics.NamespaceUpdates.WithLabelValues(metrics.ResultResynced))
+	ns.Annotations = map[string]string{"description": "test"}
+	_, err = clientset.CoreV1().Namespaces().Update(ns)
 	assert.Nil(t, err)
 
-	// Update Namespace annotation
-	ns, err = clientset.CoreV1().Namespaces().Get(ns.Name, metav1.GetOptions{})
-	ns.Annotations = map[string]string{
-		constants.WhiteListAddressesKey: "172.22.132.99,172.22.131.0/32",
-	}
+	// The invalid whitelist doesn't enqueue the services
+	ns.Annotations[constants.WhiteListAddressesKey] = "172.22.132.99,172.22.131.0/33"
+	_, err = clientset.CoreV1().Namespaces().Update(ns)
+	assert.Nil(t, err)
 
+	ns.Annotations[constants.WhiteListAddressesKey] = "172.22.132.99,172.22.131.0/32"
 	_, err = clientset.CoreV1().Namespaces().Update(ns)
 	assert.Nil(t, err)
 
+	// The invalid whitelist may be retried after the valid one is set, so the resync is counted at least once
 	failed := true
 	for start := time.Now(); time.Since(start) < timeout; {
-		sec, err = blendedset.InwinstackV1().Securities(ns.Name).Get(sec.Name, metav1.GetOptions{})
-		assert.Nil(t, err)
-		if !reflect.DeepEqual(createSec.Spec.SourceAddresses, sec.Spec.SourceAddresses) {
-			assert.Equal(t, []string{"172.22.132.99", "172.22.131.0/32"}, sec.Spec.SourceAddresses)
+		if len(services.enqueued()) > 0 {
 			failed = false
 			break
 		}
 	}
-	assert.Equal(t, false, failed, "failed to update source addresses.")
+	assert.Equal(t, false, failed, "failed to enqueue the services.")
+	assert.Equal(t, ns.Name, services.enqueued()[0])
+	assert.True(t, testutil.ToFloat64(metrics.NamespaceUpdates.WithLabelValues(metrics.UpdateSkipped)) > skipped)
+	assert.True(t, testutil.ToFloat64(metrics.NamespaceUpdates.WithLabelValues(metrics.ResultResynced)) > resynced)
+
+	cancel()
+	controller.Stop()
+}
+
+func TestNamespaceWhitelistSet(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cfg := &config.Config{
+		Threads: 2,
+	}
+
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&whitelistset.WhitelistSet{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "inwinstack.com/v1", Kind: "WhitelistSet"},
+		ObjectMeta: metav1.ObjectMeta{Name: "office"},
+		Spec:       whitelistset.WhitelistSetSpec{Addresses: []string{"172.22.0.0/16"}},
+	})
+	assert.Nil(t, err)
+	set := &unstructured.Unstructured{Object: content}
+
+	clientset := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:        "test1",
+			Annotations: map[string]string{constants.WhitelistSetsKey: "office"},
+		}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test2"}},
+	)
+	dynamicset := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+	informer := informers.NewSharedInformerFactory(clientset, 0)
+	dynamicInformer := dynamicinformer.NewDynamicSharedInformerFactory(dynamicset, 0)
+	sets := whitelistset.NewStore(dynamicInformer.ForResource(whitelistset.GroupVersionResource))
+	whitelists := service.NewWhitelistCache(informer.Core().V1().Namespaces(), sets)
+	services := &fakeEnqueuer{}
+
+	controller := NewController(cfg, informer.Core().V1().Namespaces(), whitelists, sets, services)
+	go informer.Start(ctx.Done())
+	go dynamicInformer.Start(ctx.Done())
+	assert.Nil(t, controller.Run(ctx, cfg.Threads))
+
+	// The namespace referencing the set is re-synced when the set is created and changed
+	waitForEnqueued := func(count int) {
+		failed := true
+		for start := time.Now(); time.Since(start) < timeout; {
+			if len(services.enqueued()) >= count {
+				failed = false
+				break
+			}
+		}
+		assert.Equal(t, false, failed, "failed to enqueue the services.")
+	}
+
+	_, err = dynamicset.Resource(whitelistset.GroupVersionResource).Create(set, metav1.CreateOptions{})
+	assert.Nil(t, err)
+	waitForEnqueued(1)
+
+	assert.Nil(t, unstructured.SetNestedStringSlice(set.Object, []string{"172.22.0.0/16", "10.0.0.0/8"}, "spec", "addresses"))
+	_, err = dynamicset.Resource(whitelistset.GroupVersionResource).Update(set, metav1.UpdateOptions{})
+	assert.Nil(t, err)
+	waitForEnqueued(2)
+	assert.Equal(t, []string{"test1", "test1"}, services.enqueued())
+
+	addresses, err := whitelists.Get("test1")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"10.0.0.0/8", "172.22.0.0/16"}, addresses)
 
 	cancel()
 	controller.Stop()
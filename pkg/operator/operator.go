@@ -22,9 +22,17 @@ import (
 	"time"
 
 	blended "github.com/inwinstack/blended/generated/clientset/versioned"
+	blendedinformers "github.com/inwinstack/blended/generated/informers/externalversions"
 	"github.com/inwinstack/pa-svc-syncker/pkg/config"
+	"github.com/inwinstack/pa-svc-syncker/pkg/health"
+	"github.com/inwinstack/pa-svc-syncker/pkg/metrics"
 	"github.com/inwinstack/pa-svc-syncker/pkg/operator/namespace"
 	"github.com/inwinstack/pa-svc-syncker/pkg/operator/service"
+	"github.com/inwinstack/pa-svc-syncker/pkg/plan"
+	"github.com/inwinstack/pa-svc-syncker/pkg/syncpolicy"
+	"github.com/inwinstack/pa-svc-syncker/pkg/whitelistset"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 )
@@ -37,43 +45,111 @@ type Operator struct {
 	blendedset blended.Interface
 	informer   informers.SharedInformerFactory
 
-	cfg *config.Config
+	blendedInformer blendedinformers.SharedInformerFactory
+
+	// The informer of SyncPolicy and WhitelistSet, which is nil if both are disabled
+	dynamicInformer dynamicinformer.DynamicSharedInformerFactory
+
+	cfg config.Getter
+
+	// The pending changes in dry-run mode, which is nil if dry-run is disabled
+	plan *plan.Plan
 
 	service   *service.Controller
 	namespace *namespace.Controller
 }
 
 // New creates an instance of the operator
-func New(cfg *config.Config, clientset kubernetes.Interface, blendedset blended.Interface) *Operator {
+func New(cfg config.Getter, clientset kubernetes.Interface, blendedset blended.Interface, dynamicset dynamic.Interface) *Operator {
 	o := &Operator{
 		cfg:        cfg,
 		clientset:  clientset,
 		blendedset: blendedset,
 	}
 	t := defaultSyncTime
-	if cfg.SyncSec > 30 {
-		t = time.Second * time.Duration(cfg.SyncSec)
+	if cfg.Get().SyncSec > 30 {
+		t = time.Second * time.Duration(cfg.Get().SyncSec)
 	}
 	o.informer = informers.NewSharedInformerFactory(clientset, t)
-	o.service = service.NewController(cfg, clientset, blendedset, o.informer.Core().V1().Services())
-	o.namespace = namespace.NewController(cfg, clientset, blendedset, o.informer.Core().V1().Namespaces())
+	o.blendedInformer = blendedinformers.NewSharedInformerFactory(blendedset, t)
+
+	if cfg.Get().SyncPolicy || cfg.Get().WhitelistSets {
+		o.dynamicInformer = dynamicinformer.NewDynamicSharedInformerFactory(dynamicset, t)
+	}
+
+	var policies *syncpolicy.Store
+	if cfg.Get().SyncPolicy {
+		policies = syncpolicy.NewStore(o.dynamicInformer.ForResource(syncpolicy.GroupVersionResource))
+	}
+
+	var sets *whitelistset.Store
+	if cfg.Get().WhitelistSets {
+		sets = whitelistset.NewStore(o.dynamicInformer.ForResource(whitelistset.GroupVersionResource))
+	}
+
+	if cfg.Get().DryRun {
+		o.plan = plan.New()
+	}
+
+	// The whitelists of namespaces are parsed once for both controllers
+	whitelists := service.NewWhitelistCache(o.informer.Core().V1().Namespaces(), sets)
+	o.service = service.NewController(cfg, clientset, blendedset, o.informer.Core().V1().Services(), o.informer.Core().V1().Namespaces(), o.blendedInformer.Inwinstack().V1().IPs(), o.blendedInformer.Inwinstack().V1().NATs(), o.blendedInformer.Inwinstack().V1().Securities(), policies, whitelists, o.plan)
+	o.namespace = namespace.NewController(cfg, o.informer.Core().V1().Namespaces(), whitelists, sets, o.service)
 	return o
 }
 
 // Run serves an isntance of the operator
 func (o *Operator) Run(ctx context.Context) error {
 	go o.informer.Start(ctx.Done())
+	go o.blendedInformer.Start(ctx.Done())
+	if o.dynamicInformer != nil {
+		go o.dynamicInformer.Start(ctx.Done())
+	}
 
-	if err := o.service.Run(ctx, o.cfg.Threads); err != nil {
+	if err := o.service.Run(ctx, o.cfg.Get().Threads); err != nil {
 		return fmt.Errorf("failed to run service controller: %s", err.Error())
 	}
 
-	if err := o.namespace.Run(ctx, o.cfg.Threads); err != nil {
+	if err := o.namespace.Run(ctx, o.cfg.Get().Threads); err != nil {
 		return fmt.Errorf("failed to run namespace controller: %s", err.Error())
 	}
 	return nil
 }
 
+// RegisterMetrics registers the collector which counts the managed objects from the informer caches
+func (o *Operator) RegisterMetrics() error {
+	return metrics.RegisterObjectsCollector(o.blendedInformer.Inwinstack().V1().NATs(), o.blendedInformer.Inwinstack().V1().Securities())
+}
+
+// Plan returns the pending changes in dry-run mode, or nil if dry-run is disabled
+func (o *Operator) Plan() *plan.Plan {
+	return o.plan
+}
+
+// Ready returns true when the informer caches of all controllers have been synced
+func (o *Operator) Ready() bool {
+	return o.service.Ready() && o.namespace.Ready()
+}
+
+// Healthy returns an error if any controller is unhealthy
+func (o *Operator) Healthy() error {
+	window := health.DefaultWindow
+	if w := o.cfg.Get().HealthCheckWindow.Duration; w > 0 {
+		window = w
+	}
+
+	if err := o.service.Healthy(window); err != nil {
+		return err
+	}
+	return o.namespace.Healthy(window)
+}
+
+// Resync enqueues all services, so that the changes of config are applied. The Securities are only
+// rendered by the service controller, so the namespaces don't need to be resynced.
+func (o *Operator) Resync() {
+	o.service.Resync()
+}
+
 // Stop stops all controllers
 func (o *Operator) Stop() {
 	o.service.Stop()
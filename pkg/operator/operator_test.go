@@ -20,18 +20,24 @@ import (
 	blendedfake "github.com/inwinstack/blended/generated/clientset/versioned/fake"
 	"github.com/inwinstack/pa-svc-syncker/pkg/config"
 	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
 	"k8s.io/client-go/kubernetes/fake"
 )
 
 func TestOperator(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
-	cfg := &config.Config{Threads: 2}
+	cfg := &config.Config{Threads: 2, SyncPolicy: true}
 	clientset := fake.NewSimpleClientset()
 	blendedset := blendedfake.NewSimpleClientset()
+	dynamicset := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
 
-	op := New(cfg, clientset, blendedset)
+	op := New(cfg, clientset, blendedset, dynamicset)
 	assert.NotNil(t, op)
+	assert.False(t, op.Ready())
 	assert.Nil(t, op.Run(ctx))
+	assert.True(t, op.Ready())
+	assert.Nil(t, op.Healthy())
 
 	cancel()
 	op.Stop()
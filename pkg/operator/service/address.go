@@ -0,0 +1,156 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/inwinstack/pa-svc-syncker/pkg/constants"
+	"github.com/thoas/go-funk"
+	v1 "k8s.io/api/core/v1"
+)
+
+// ParsePublicIPs parses the comma-separated public IPs from the annotation value
+func ParsePublicIPs(value string) ([]string, error) {
+	addresses := []string{}
+	for _, s := range strings.Split(value, ",") {
+		s = strings.TrimSpace(s)
+		if len(s) == 0 {
+			continue
+		}
+
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid public IP '%s'", s)
+		}
+		addresses = append(addresses, ip.String())
+	}
+	return funk.UniqString(addresses), nil
+}
+
+// pairAddresses pairs the public IPs with the external IPs of the same family by position. Each family
+// must have as many public IPs as external IPs, so that every external IP is exposed by exactly one
+// public IP.
+func pairAddresses(addresses []string, svc *v1.Service) (map[string]string, error) {
+	externalIPs := svc.Spec.ExternalIPs
+	if len(externalIPs) == 0 {
+		return nil, fmt.Errorf("failed to get the external IP")
+	}
+
+	families := map[bool][]string{}
+	for _, s := range externalIPs {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid external IP '%s'", s)
+		}
+		families[isIPv6(s)] = append(families[isIPv6(s)], s)
+	}
+
+	publics := map[bool][]string{}
+	for _, addr := range addresses {
+		publics[isIPv6(addr)] = append(publics[isIPv6(addr)], addr)
+	}
+
+	pairs := map[string]string{}
+	for _, v6 := range []bool{false, true} {
+		if len(publics[v6]) > 0 && len(families[v6]) == 0 {
+			return nil, fmt.Errorf("no %s external IP for the public IP '%s'", familyName(v6), strings.Join(publics[v6], ","))
+		}
+
+		if len(publics[v6]) != len(families[v6]) {
+			return nil, fmt.Errorf("%d %s public IPs can't be paired with %d %s external IPs", len(publics[v6]), familyName(v6), len(families[v6]), familyName(v6))
+		}
+
+		for i, addr := range publics[v6] {
+			pairs[addr] = families[v6][i]
+		}
+	}
+	return pairs, nil
+}
+
+// sharedExternalIP returns the external IP paired with the public IP by the first owner, so that the NAT
+// shared by the owners is deterministic regardless of which owner is synced last
+func sharedExternalIP(addr string, owners []*v1.Service) (string, *v1.Service) {
+	for _, owner := range sortOwners(owners) {
+		addresses, err := ParsePublicIPs(owner.Annotations[constants.PublicIPKey])
+		if err != nil {
+			continue
+		}
+
+		pairs, err := pairAddresses(addresses, owner)
+		if err != nil {
+			continue
+		}
+
+		if externalIP, ok := pairs[addr]; ok {
+			return externalIP, owner
+		}
+	}
+	return "", nil
+}
+
+// isIPv6 checks whether the IP or CIDR address is IPv6
+func isIPv6(addr string) bool {
+	if ipnet := toIPNet(addr); ipnet != nil {
+		return ipnet.IP.To4() == nil
+	}
+	return false
+}
+
+func familyName(v6 bool) string {
+	if v6 {
+		return "IPv6"
+	}
+	return "IPv4"
+}
+
+// encodeAddress encodes the public IP for the object names and label values, which don't allow
+// colons. The IPv6 address is expanded to the hex groups joined by dashes, e.g. "2001-0db8-0000-...-0001".
+func encodeAddress(addr string) string {
+	ip := net.ParseIP(addr)
+	if ip == nil || ip.To4() != nil {
+		return addr
+	}
+
+	groups := make([]string, 0, net.IPv6len/2)
+	for i := 0; i < net.IPv6len; i += 2 {
+		groups = append(groups, fmt.Sprintf("%02x%02x", ip[i], ip[i+1]))
+	}
+	return strings.Join(groups, "-")
+}
+
+// decodeAddress decodes the public IP from the object name or label value
+func decodeAddress(value string) string {
+	if ip := net.ParseIP(strings.Replace(value, "-", ":", -1)); ip != nil && strings.Contains(value, "-") {
+		return ip.String()
+	}
+	return value
+}
+
+// policyName returns the name of NAT and Security for the public IP
+func policyName(addr string) string {
+	return fmt.Sprintf("%s-%s", constants.PolicyPrefix, encodeAddress(addr))
+}
+
+// subtractAddresses returns the addresses of a which are not in b
+func subtractAddresses(a, b []string) []string {
+	return funk.FilterString(a, func(s string) bool {
+		return !funk.ContainsString(b, s)
+	})
+}
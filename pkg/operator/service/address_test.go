@@ -0,0 +1,156 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"testing"
+
+	blendedv1 "github.com/inwinstack/blended/apis/inwinstack/v1"
+	"github.com/inwinstack/pa-svc-syncker/pkg/constants"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParsePublicIPs(t *testing.T) {
+	tests := []struct {
+		Value     string
+		Addresses []string
+		Error     bool
+	}{
+		{Value: "", Addresses: []string{}},
+		{Value: "140.11.22.33", Addresses: []string{"140.11.22.33"}},
+		{Value: "140.11.22.33, 140.11.22.34,140.11.22.33", Addresses: []string{"140.11.22.33", "140.11.22.34"}},
+		{Value: "140.11.22.33,140.11.22", Error: true},
+		{Value: "2001:DB8:0::1,140.11.22.33", Addresses: []string{"2001:db8::1", "140.11.22.33"}},
+	}
+
+	for _, test := range tests {
+		addresses, err := ParsePublicIPs(test.Value)
+		assert.Equal(t, test.Error, err != nil)
+		assert.Equal(t, test.Addresses, addresses)
+	}
+}
+
+func TestPairAddresses(t *testing.T) {
+	tests := []struct {
+		Addresses   []string
+		ExternalIPs []string
+		Pairs       map[string]string
+	}{
+		{
+			Addresses:   []string{"140.11.22.33"},
+			ExternalIPs: []string{"172.11.22.33"},
+			Pairs:       map[string]string{"140.11.22.33": "172.11.22.33"},
+		},
+		{
+			Addresses:   []string{"140.11.22.33", "140.11.22.34"},
+			ExternalIPs: []string{"172.11.22.33", "172.11.22.34"},
+			Pairs:       map[string]string{"140.11.22.33": "172.11.22.33", "140.11.22.34": "172.11.22.34"},
+		},
+		{
+			// The extra external IP would never be exposed
+			Addresses:   []string{"140.11.22.33"},
+			ExternalIPs: []string{"172.11.22.33", "172.11.22.34"},
+			Pairs:       nil,
+		},
+		{
+			// The extra public IP would reuse the external IP
+			Addresses:   []string{"140.11.22.33", "140.11.22.34"},
+			ExternalIPs: []string{"172.11.22.33"},
+			Pairs:       nil,
+		},
+		{
+			Addresses:   []string{"140.11.22.33"},
+			ExternalIPs: nil,
+			Pairs:       nil,
+		},
+		{
+			Addresses:   []string{"140.11.22.33", "2001:db8::1", "2001:db8::2"},
+			ExternalIPs: []string{"fd00::1", "172.11.22.33", "fd00::2"},
+			Pairs:       map[string]string{"140.11.22.33": "172.11.22.33", "2001:db8::1": "fd00::1", "2001:db8::2": "fd00::2"},
+		},
+		{
+			Addresses:   []string{"140.11.22.33"},
+			ExternalIPs: []string{"172.11.22.33", "fd00::1"},
+			Pairs:       nil,
+		},
+		{
+			Addresses:   []string{"2001:db8::1"},
+			ExternalIPs: []string{"172.11.22.33"},
+			Pairs:       nil,
+		},
+		{
+			Addresses:   []string{"140.11.22.33"},
+			ExternalIPs: []string{"172.11.22"},
+			Pairs:       nil,
+		},
+	}
+
+	for _, test := range tests {
+		svc := &corev1.Service{Spec: corev1.ServiceSpec{ExternalIPs: test.ExternalIPs}}
+		pairs, err := pairAddresses(test.Addresses, svc)
+		assert.Equal(t, test.Pairs == nil, err != nil)
+		assert.Equal(t, test.Pairs, pairs)
+	}
+}
+
+func TestSharedExternalIP(t *testing.T) {
+	newOwner := func(name, addresses string, externalIPs ...string) *corev1.Service {
+		return &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: map[string]string{constants.PublicIPKey: addresses}},
+			Spec:       corev1.ServiceSpec{ExternalIPs: externalIPs},
+		}
+	}
+
+	// The owners sharing the public IP always take the pair of the first owner
+	a := newOwner("svc-a", "140.11.22.33", "172.11.22.33")
+	b := newOwner("svc-b", "140.11.22.33", "172.11.22.34")
+	for _, owners := range [][]*corev1.Service{{a, b}, {b, a}} {
+		externalIP, owner := sharedExternalIP("140.11.22.33", owners)
+		assert.Equal(t, "172.11.22.33", externalIP)
+		assert.Equal(t, a, owner)
+	}
+
+	// The owner which can't be paired is skipped
+	invalid := newOwner("svc-0", "140.11.22.33,140.11.22.34", "172.11.22.35")
+	externalIP, owner := sharedExternalIP("140.11.22.33", []*corev1.Service{invalid, b})
+	assert.Equal(t, "172.11.22.34", externalIP)
+	assert.Equal(t, b, owner)
+
+	_, owner = sharedExternalIP("140.11.22.33", []*corev1.Service{invalid})
+	assert.Nil(t, owner)
+}
+
+func TestEncodeAddress(t *testing.T) {
+	tests := []struct {
+		Addr    string
+		Encoded string
+	}{
+		{Addr: "140.11.22.33", Encoded: "140.11.22.33"},
+		{Addr: "2001:db8::1", Encoded: "2001-0db8-0000-0000-0000-0000-0000-0001"},
+		{Addr: "::1", Encoded: "0000-0000-0000-0000-0000-0000-0000-0001"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.Encoded, encodeAddress(test.Addr))
+		assert.Equal(t, test.Addr, decodeAddress(test.Encoded))
+	}
+	assert.Equal(t, "k8s-2001-0db8-0000-0000-0000-0000-0000-0001", policyName("2001:db8::1"))
+	assert.Equal(t, blendedv1.NATIPv6, natType("2001:db8::1"))
+	assert.Equal(t, blendedv1.NATIPv4, natType("140.11.22.33"))
+}
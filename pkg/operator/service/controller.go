@@ -19,43 +19,81 @@ package service
 import (
 	"context"
 	"fmt"
-	"net"
+	"reflect"
+	"strings"
 	"time"
 
 	"github.com/golang/glog"
 	blended "github.com/inwinstack/blended/generated/clientset/versioned"
+	blendedinformerv1 "github.com/inwinstack/blended/generated/informers/externalversions/inwinstack/v1"
+	"github.com/inwinstack/blended/k8sutil"
 	"github.com/inwinstack/pa-svc-syncker/pkg/config"
 	"github.com/inwinstack/pa-svc-syncker/pkg/constants"
+	"github.com/inwinstack/pa-svc-syncker/pkg/health"
+	"github.com/inwinstack/pa-svc-syncker/pkg/metrics"
+	"github.com/inwinstack/pa-svc-syncker/pkg/plan"
+	"github.com/inwinstack/pa-svc-syncker/pkg/syncpolicy"
 	"github.com/thoas/go-funk"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/labels"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
 	informerv1 "k8s.io/client-go/informers/core/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	listerv1 "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 )
 
+const serviceQueueName = "Services"
+
 // Controller represents the controller of service
 type Controller struct {
-	cfg *config.Config
+	cfg config.Getter
 
 	clientset  kubernetes.Interface
 	blendedset blended.Interface
 	lister     listerv1.ServiceLister
 	synced     cache.InformerSynced
+	nsLister   listerv1.NamespaceLister
+	nsSynced   cache.InformerSynced
+	ipIndexer  cache.Indexer
+	ipSynced   cache.InformerSynced
+	natSynced  cache.InformerSynced
+	secSynced  cache.InformerSynced
+	policies   *syncpolicy.Store
+	whitelists *WhitelistCache
+	plan       *plan.Plan
 	queue      workqueue.RateLimitingInterface
+	probe      *health.WorkerProbe
+	recorder   record.EventRecorder
 }
 
 // NewController creates an instance of the service controller
 func NewController(
-	cfg *config.Config,
+	cfg config.Getter,
 	clientset kubernetes.Interface,
 	blendedset blended.Interface,
-	informer informerv1.ServiceInformer) *Controller {
+	informer informerv1.ServiceInformer,
+	nsInformer informerv1.NamespaceInformer,
+	ipInformer blendedinformerv1.IPInformer,
+	natInformer blendedinformerv1.NATInformer,
+	secInformer blendedinformerv1.SecurityInformer,
+	policies *syncpolicy.Store,
+	whitelists *WhitelistCache,
+	plan *plan.Plan) *Controller {
+
+	// The events are only logged in dry-run mode, since nothing is written
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartLogging(glog.Infof)
+	if plan == nil {
+		broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events("")})
+	}
 
 	controller := &Controller{
 		cfg:        cfg,
@@ -63,7 +101,18 @@ func NewController(
 		blendedset: blendedset,
 		lister:     informer.Lister(),
 		synced:     informer.Informer().HasSynced,
-		queue:      workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "Services"),
+		nsLister:   nsInformer.Lister(),
+		nsSynced:   nsInformer.Informer().HasSynced,
+		ipIndexer:  ipInformer.Informer().GetIndexer(),
+		ipSynced:   ipInformer.Informer().HasSynced,
+		natSynced:  natInformer.Informer().HasSynced,
+		secSynced:  secInformer.Informer().HasSynced,
+		policies:   policies,
+		whitelists: whitelists,
+		plan:       plan,
+		queue:      workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), serviceQueueName),
+		probe:      health.NewWorkerProbe(),
+		recorder:   broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: constants.ManagedByValue}),
 	}
 	glog.Info("Setting up the Service event handlers.")
 
@@ -73,6 +122,53 @@ func NewController(
 			controller.enqueue(new)
 		},
 	})
+
+	// Syncs or releases the services when the namespace is selected or deselected by labels
+	nsInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			controller.enqueueNamespace(nil, obj)
+		},
+		UpdateFunc: controller.enqueueNamespace,
+	})
+
+	if err := ipInformer.Informer().AddIndexers(cache.Indexers{ipAddressIndex: ipAddressIndexFunc}); err != nil {
+		utilruntime.HandleError(err)
+	}
+
+	// Re-syncs the services when the IP is allocated, changed or released
+	ipInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			controller.enqueueIP(nil, obj)
+		},
+		UpdateFunc: controller.enqueueIP,
+		DeleteFunc: func(obj interface{}) {
+			controller.enqueueIP(obj, nil)
+		},
+	})
+
+	// Restores the NAT and Security which are changed or deleted by hand
+	for _, informer := range []cache.SharedIndexInformer{natInformer.Informer(), secInformer.Informer()} {
+		informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			UpdateFunc: func(old, new interface{}) {
+				// The periodic resync sends the same object, which doesn't need to be restored
+				if !reflect.DeepEqual(old, new) {
+					controller.enqueueOwner(new)
+				}
+			},
+			DeleteFunc: controller.enqueueOwner,
+		})
+	}
+
+	// Re-renders the services which are affected by the SyncPolicy
+	policies.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			controller.enqueuePolicy(obj, nil)
+		},
+		UpdateFunc: controller.enqueuePolicy,
+		DeleteFunc: func(obj interface{}) {
+			controller.enqueuePolicy(obj, nil)
+		},
+	})
 	return controller
 }
 
@@ -80,19 +176,52 @@ func NewController(
 func (c *Controller) Run(ctx context.Context, threadiness int) error {
 	glog.Info("Starting Service controller")
 	glog.Info("Waiting for Service informer caches to sync")
-	if ok := cache.WaitForCacheSync(ctx.Done(), c.synced); !ok {
+	if ok := cache.WaitForCacheSync(ctx.Done(), c.synced, c.nsSynced, c.ipSynced, c.natSynced, c.secSynced, c.policies.HasSynced, c.whitelists.HasSynced); !ok {
 		return fmt.Errorf("failed to wait for caches to sync")
 	}
+	c.probe.SetReady()
 
 	glog.Info("Starting Service workers")
 	for i := 0; i < threadiness; i++ {
 		go wait.Until(c.runWorker, time.Second, ctx.Done())
 	}
 
+	if period := c.cfg.Get().GCPeriod.Duration; period > 0 {
+		glog.Infof("Starting Service garbage collection every %s", period)
+		go wait.Until(c.collectGarbage, period, ctx.Done())
+	}
+
 	glog.Info("Started Service workers")
 	return nil
 }
 
+// Ready returns true when the Service informer caches have been synced
+func (c *Controller) Ready() bool {
+	return c.probe.Ready()
+}
+
+// Healthy returns an error if the workers haven't processed any item in the window
+func (c *Controller) Healthy(window time.Duration) error {
+	if err := c.probe.Healthy(c.queue.Len(), window); err != nil {
+		return fmt.Errorf("Service controller is unhealthy: %s", err.Error())
+	}
+	return nil
+}
+
+// Resync enqueues all services, e.g. after the config has been changed
+func (c *Controller) Resync() {
+	svcs, err := c.lister.List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+
+	glog.Infof("Service controller resyncing %d services", len(svcs))
+	for _, svc := range svcs {
+		c.enqueue(svc)
+	}
+}
+
 // Stop stops the service controller
 func (c *Controller) Stop() {
 	glog.Info("Stopping the Service controller")
@@ -120,7 +249,11 @@ func (c *Controller) processNextWorkItem() bool {
 			return nil
 		}
 
-		if err := c.reconcile(key); err != nil {
+		start := time.Now()
+		err := c.reconcile(key)
+		metrics.ObserveReconcile(serviceQueueName, start, err)
+		c.probe.Processed()
+		if err != nil {
 			c.queue.AddRateLimited(key)
 			return fmt.Errorf("Service controller error syncing '%s': %s, requeuing", key, err.Error())
 		}
@@ -139,8 +272,16 @@ func (c *Controller) processNextWorkItem() bool {
 
 func (c *Controller) enqueue(obj interface{}) {
 	svc := obj.(*v1.Service).DeepCopy()
-	if funk.Contains(c.cfg.IgnoreNamespaces, svc.Namespace) {
-		glog.V(3).Infof("Service controller ignored '%s/%s'", svc.Namespace, svc.Name)
+	selected, err := c.isSelected(svc)
+	if err != nil && !errors.IsNotFound(err) {
+		utilruntime.HandleError(err)
+		return
+	}
+
+	// The service which isn't selected is skipped, unless it has been synced and needs to be released.
+	// If the namespace isn't in the cache yet, the service is enqueued again when the namespace is added.
+	if !selected && !funk.ContainsString(svc.Finalizers, constants.ServiceFinalizer) {
+		glog.V(3).Infof("Service controller skipped '%s/%s' which is not selected", svc.Namespace, svc.Name)
 		return
 	}
 
@@ -152,6 +293,92 @@ func (c *Controller) enqueue(obj interface{}) {
 	c.queue.Add(key)
 }
 
+// EnqueueServices enqueues the services of namespace, e.g. after the namespace whitelist has been changed
+func (c *Controller) EnqueueServices(namespace string) {
+	svcs, err := c.lister.Services(namespace).List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+
+	for _, svc := range svcs {
+		c.enqueue(svc)
+	}
+}
+
+// enqueueOwner enqueues the service which owns the managed NAT or Security
+func (c *Controller) enqueueOwner(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+
+	meta, err := apimeta.Accessor(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+
+	if meta.GetLabels()[constants.ManagedByKey] != constants.ManagedByValue {
+		return
+	}
+
+	for _, key := range ownerKeys(meta) {
+		namespace, name, err := cache.SplitMetaNamespaceKey(key)
+		if err != nil {
+			utilruntime.HandleError(err)
+			continue
+		}
+
+		svc, err := c.lister.Services(namespace).Get(name)
+		if err != nil {
+			if !errors.IsNotFound(err) {
+				utilruntime.HandleError(err)
+			}
+			continue
+		}
+
+		glog.V(3).Infof("Service controller detected the change of '%s/%s', requeuing '%s'", meta.GetNamespace(), meta.GetName(), key)
+		c.enqueue(svc)
+	}
+}
+
+// enqueuePolicy enqueues the services which are selected by the old or the new SyncPolicy
+func (c *Controller) enqueuePolicy(old, new interface{}) {
+	policies := []*syncpolicy.SyncPolicy{}
+	for _, obj := range []interface{}{old, new} {
+		if obj == nil {
+			continue
+		}
+
+		policy, err := syncpolicy.Convert(obj)
+		if err != nil {
+			utilruntime.HandleError(err)
+			continue
+		}
+		policies = append(policies, policy)
+	}
+
+	svcs, err := c.lister.List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+
+	for _, svc := range svcs {
+		ns, err := c.nsLister.Get(svc.Namespace)
+		if err != nil {
+			continue
+		}
+
+		for _, policy := range policies {
+			if ok, _ := policy.Matches(ns, svc); ok {
+				c.enqueue(svc)
+				break
+			}
+		}
+	}
+}
+
 func (c *Controller) reconcile(key string) error {
 	namespace, name, err := cache.SplitMetaNamespaceKey(key)
 	if err != nil {
@@ -162,63 +389,279 @@ func (c *Controller) reconcile(key string) error {
 	svc, err := c.lister.Services(namespace).Get(name)
 	if err != nil {
 		if errors.IsNotFound(err) {
-			utilruntime.HandleError(fmt.Errorf("service '%s' in work queue no longer exists", key))
-			return err
+			glog.V(3).Infof("Service controller ignored '%s' which no longer exists", key)
+			return nil
 		}
 		return err
 	}
 
-	// If service was deleted, it will clean up NAT, and Security
+	// If service was deleted, it will clean up NAT, and Security before releasing the finalizer
 	if !svc.ObjectMeta.DeletionTimestamp.IsZero() {
+		if !funk.ContainsString(svc.Finalizers, constants.ServiceFinalizer) {
+			return nil
+		}
+
 		if err := c.cleanup(svc); err != nil {
 			return err
 		}
+		return c.removeFinalizer(svc)
+	}
+
+	// If service is no longer selected, e.g. its type or labels were changed, it releases the NAT and Security
+	selected, err := c.isSelected(svc)
+	if err != nil {
+		return err
+	}
+
+	if !selected {
+		if !funk.ContainsString(svc.Finalizers, constants.ServiceFinalizer) {
+			return nil
+		}
+		return c.unmanage(svc)
+	}
+
+	newSvc, addresses, err := c.syncAddresses(svc)
+	if err != nil {
+		c.recorder.Event(svc, v1.EventTypeWarning, constants.SyncFailedReason, err.Error())
+		if err := c.updateStatus(newSvc, nil, err); err != nil {
+			utilruntime.HandleError(err)
+		}
+		return err
+	}
+	return c.updateStatus(newSvc, addresses, nil)
+}
+
+// syncAddresses syncs all public IPs of service, and returns the latest service and the public IPs that were synced
+func (c *Controller) syncAddresses(svc *v1.Service) (*v1.Service, []string, error) {
+	addresses, err := ParsePublicIPs(svc.Annotations[constants.PublicIPKey])
+	if err != nil || len(addresses) == 0 {
+		return svc, nil, fmt.Errorf("failed to get the public IP")
+	}
+
+	pairs, err := pairAddresses(addresses, svc)
+	if err != nil {
+		return svc, nil, err
+	}
+
+	// Records all public IPs before syncing, so that the released public IPs can be cleaned up
+	// even if the syncing failed.
+	synced, _ := ParsePublicIPs(svc.Annotations[constants.SyncedPublicIPKey])
+	released := subtractAddresses(synced, addresses)
+	newSvc, err := c.recordPublicIPs(svc, append(addresses, released...))
+	if err != nil {
+		return svc, nil, err
+	}
+
+	inactive := []string{}
+	for _, addr := range addresses {
+		active, err := c.sync(addr, pairs[addr], newSvc)
+		if err != nil {
+			return newSvc, nil, err
+		}
+
+		if !active {
+			inactive = append(inactive, addr)
+		}
+	}
+
+	for _, addr := range released {
+		if err := c.release(addr, newSvc); err != nil {
+			return newSvc, nil, err
+		}
+	}
+
+	newSvc, err = c.recordPublicIPs(newSvc, addresses)
+	if err != nil {
+		return svc, nil, err
+	}
+
+	if len(inactive) > 0 {
+		return newSvc, nil, fmt.Errorf("the public IP '%s' is not active", strings.Join(inactive, ","))
+	}
+	return newSvc, addresses, nil
+}
+
+// sync syncs the service objects, NAT and Security of the public IP. The NAT is only programmed when
+// the IP of public IP is active, otherwise it is removed and sync returns false.
+func (c *Controller) sync(addr, externalIP string, svc *v1.Service) (bool, error) {
+	svcs, err := c.servicesByAddress(svc.Namespace, addr, "")
+	if err != nil {
+		return false, err
+	}
+
+	services, err := c.syncServiceObjects(addr, svcs)
+	if err != nil {
+		return false, err
+	}
+
+	active, err := c.isActiveIP(svc.Namespace, addr)
+	if err != nil {
+		return false, err
+	}
+
+	if shared, owner := sharedExternalIP(addr, svcs); owner != nil && shared != externalIP {
+		c.recorder.Eventf(svc, v1.EventTypeWarning, constants.ExternalIPConflictReason, "The public IP '%s' is paired with the external IP '%s' of '%s' instead of '%s'", addr, shared, owner.Name, externalIP)
+		externalIP = shared
+	}
+
+	if active {
+		if err := c.syncNAT(addr, externalIP, svcs, svc); err != nil {
+			return false, err
+		}
+	} else {
+		glog.V(2).Infof("Service controller skipped the NAT of inactive public IP '%s'", addr)
+		if err := c.deleteNAT(addr, svc); err != nil {
+			return false, err
+		}
+	}
+
+	if err := c.syncSecurity(addr, services, svcs, svc); err != nil {
+		return false, err
+	}
+	return active, nil
+}
+
+// recordPublicIPs makes sure the service has the finalizer and records the public IPs that will be synced,
+// so that the NAT and Security can be found even if the public IP annotation was removed.
+func (c *Controller) recordPublicIPs(svc *v1.Service, addresses []string) (*v1.Service, error) {
+	value := strings.Join(addresses, ",")
+	if funk.ContainsString(svc.Finalizers, constants.ServiceFinalizer) &&
+		svc.Annotations[constants.SyncedPublicIPKey] == value {
+		return svc, nil
+	}
+
+	svcCopy := svc.DeepCopy()
+	k8sutil.AddFinalizer(&svcCopy.ObjectMeta, constants.ServiceFinalizer)
+	svcCopy.Annotations[constants.SyncedPublicIPKey] = value
+	if c.plan != nil {
+		return svcCopy, nil
+	}
+	return c.clientset.CoreV1().Services(svcCopy.Namespace).Update(svcCopy)
+}
+
+func (c *Controller) removeFinalizer(svc *v1.Service) error {
+	// The finalizer is left to the syncker which isn't in dry-run mode
+	if c.plan != nil {
 		return nil
 	}
 
-	address := net.ParseIP(svc.Annotations[constants.PublicIPKey])
-	if address == nil {
-		return fmt.Errorf("failed to get the public IP")
+	svcCopy := svc.DeepCopy()
+	k8sutil.RemoveFinalizer(&svcCopy.ObjectMeta, constants.ServiceFinalizer)
+	if _, err := c.clientset.CoreV1().Services(svcCopy.Namespace).Update(svcCopy); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
 	}
+	return nil
+}
 
-	if err := c.createNAT(address.String(), svc); err != nil {
+// unmanage cleans up the service which is no longer managed, and removes the finalizer and sync records
+func (c *Controller) unmanage(svc *v1.Service) error {
+	glog.Infof("Service controller releasing '%s/%s' which is no longer managed", svc.Namespace, svc.Name)
+	if err := c.cleanup(svc); err != nil {
 		return err
 	}
 
-	if err := c.createSecurity(address.String(), svc); err != nil {
+	if c.plan != nil {
+		return nil
+	}
+
+	svcCopy := svc.DeepCopy()
+	k8sutil.RemoveFinalizer(&svcCopy.ObjectMeta, constants.ServiceFinalizer)
+	delete(svcCopy.Annotations, constants.SyncedPublicIPKey)
+	delete(svcCopy.Annotations, constants.SyncStatusKey)
+	if _, err := c.clientset.CoreV1().Services(svcCopy.Namespace).Update(svcCopy); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
 		return err
 	}
 	return nil
 }
 
+// servicesByAddress lists the services of namespace which are used the public IP, except the given name
+func (c *Controller) servicesByAddress(namespace, addr, except string) ([]*v1.Service, error) {
+	svcs, err := c.lister.Services(namespace).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	items := []*v1.Service{}
+	for _, s := range svcs {
+		if s.Name == except || !s.ObjectMeta.DeletionTimestamp.IsZero() || !IsManaged(c.cfg.Get(), s) {
+			continue
+		}
+
+		addresses, _ := ParsePublicIPs(s.Annotations[constants.PublicIPKey])
+		if funk.ContainsString(addresses, addr) {
+			items = append(items, s)
+		}
+	}
+	return items, nil
+}
+
 func (c *Controller) cleanup(svc *v1.Service) error {
-	svcCopy := svc.DeepCopy()
-	address := net.ParseIP(svcCopy.Annotations[constants.PublicIPKey])
-	if address == nil {
+	// The public IP annotation may be removed before deleting,
+	// so it also releases the public IPs that were synced.
+	addresses, _ := ParsePublicIPs(svc.Annotations[constants.PublicIPKey])
+	synced, _ := ParsePublicIPs(svc.Annotations[constants.SyncedPublicIPKey])
+	addresses = append(addresses, subtractAddresses(synced, addresses)...)
+	if len(addresses) == 0 {
+		glog.Warningf("Service controller cannot find the public IP of '%s/%s' for cleanup", svc.Namespace, svc.Name)
 		return nil
 	}
 
-	svcs, err := c.clientset.CoreV1().Services(svcCopy.Namespace).List(metav1.ListOptions{})
+	for _, addr := range addresses {
+		if err := c.release(addr, svc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// release releases the service objects, NAT and Security of the public IP which is no longer used by service
+func (c *Controller) release(addr string, svc *v1.Service) error {
+	others, err := c.servicesByAddress(svc.Namespace, addr, svc.Name)
 	if err != nil {
 		return err
 	}
 
-	items := funk.Filter(svcs.Items, func(s v1.Service) bool {
-		v := s.Annotations[constants.PublicIPKey]
-		return v == address.String()
-	})
+	// If this namespace has other services are used the same public IP, it will not release the Security
+	// and NAT, but resync them from the other services. The NAT takes the external IP of the first other
+	// service, and it is removed if no other service can pair the public IP or the IP isn't active.
+	if len(others) > 0 {
+		services, err := c.syncServiceObjects(addr, others)
+		if err != nil {
+			return err
+		}
 
-	// If this namespace has other services are used the same public IP,
-	// it will not release the Security and NAT.
-	if len(items.([]v1.Service)) > 1 {
-		return nil
+		active, err := c.isActiveIP(svc.Namespace, addr)
+		if err != nil {
+			return err
+		}
+
+		if externalIP, owner := sharedExternalIP(addr, others); owner != nil && active {
+			err = c.syncNAT(addr, externalIP, others, owner)
+		} else {
+			err = c.deleteNAT(addr, svc)
+		}
+
+		if err != nil {
+			return err
+		}
+		return c.syncSecurity(addr, services, others, svc)
+	}
+
+	if err := c.deleteNAT(addr, svc); err != nil {
+		return err
 	}
 
-	if err := c.deleteNAT(address.String(), svcCopy); err != nil {
+	if err := c.deleteSecurity(addr, svc); err != nil {
 		return err
 	}
 
-	if err := c.deleteSecurity(address.String(), svcCopy); err != nil {
+	if err := c.deleteServiceObjects(addr); err != nil {
 		return err
 	}
 	return nil
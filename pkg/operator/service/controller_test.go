@@ -19,22 +19,65 @@ package service
 import (
 	"context"
 	"fmt"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	blendedv1 "github.com/inwinstack/blended/apis/inwinstack/v1"
 	blendedfake "github.com/inwinstack/blended/generated/clientset/versioned/fake"
+	blendedinformers "github.com/inwinstack/blended/generated/informers/externalversions"
 	"github.com/inwinstack/pa-svc-syncker/pkg/config"
 	"github.com/inwinstack/pa-svc-syncker/pkg/constants"
+	"github.com/inwinstack/pa-svc-syncker/pkg/metrics"
+	"github.com/inwinstack/pa-svc-syncker/pkg/plan"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
 )
 
 const timeout = 3 * time.Second
 
+// waitForIP waits for the IP informer cache, so that the service is synced with the active IP
+func waitForIP(controller *Controller, namespace, addr string) {
+	for start := time.Now(); time.Since(start) < timeout; {
+		if active, _ := controller.isActiveIP(namespace, addr); active {
+			return
+		}
+	}
+}
+
+// swappedConfig is the config getter whose config can be replaced while the workers are running
+type swappedConfig struct {
+	value atomic.Value
+}
+
+func newSwappedConfig(cfg *config.Config) *swappedConfig {
+	getter := &swappedConfig{}
+	getter.Set(cfg)
+	return getter
+}
+
+func (g *swappedConfig) Get() *config.Config {
+	return g.value.Load().(*config.Config)
+}
+
+func (g *swappedConfig) Set(cfg *config.Config) {
+	g.value.Store(cfg)
+}
+
+func newIP(namespace, name, addr string, phase blendedv1.IPPhase) *blendedv1.IP {
+	return &blendedv1.IP{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec:       blendedv1.IPSpec{PoolName: "test"},
+		Status:     blendedv1.IPStatus{Phase: phase, Address: addr},
+	}
+}
+
 func TestServiceController(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	cfg := &config.Config{
@@ -46,7 +89,6 @@ func TestServiceController(t *testing.T) {
 		HipProfiles:      []string{"any"},
 		Applications:     []string{"any"},
 		Categories:       []string{"any"},
-		Services:         []string{"k8s-tcp", "k8s-udp"},
 		GroupName:        "",
 		LogSettingName:   "",
 	}
@@ -54,9 +96,13 @@ func TestServiceController(t *testing.T) {
 	clientset := fake.NewSimpleClientset()
 	blendedset := blendedfake.NewSimpleClientset()
 	informer := informers.NewSharedInformerFactory(clientset, 0)
+	blendedInformer := blendedinformers.NewSharedInformerFactory(blendedset, 0)
 
-	controller := NewController(cfg, clientset, blendedset, informer.Core().V1().Services())
+	recorder := record.NewFakeRecorder(100)
+	controller := NewController(cfg, clientset, blendedset, informer.Core().V1().Services(), informer.Core().V1().Namespaces(), blendedInformer.Inwinstack().V1().IPs(), blendedInformer.Inwinstack().V1().NATs(), blendedInformer.Inwinstack().V1().Securities(), nil, nil, nil)
+	controller.recorder = recorder
 	go informer.Start(ctx.Done())
+	go blendedInformer.Start(ctx.Done())
 	assert.Nil(t, controller.Run(ctx, cfg.Threads))
 
 	ns := &corev1.Namespace{
@@ -85,6 +131,7 @@ func TestServiceController(t *testing.T) {
 	}
 	_, iperr := blendedset.InwinstackV1().IPs(ip.Namespace).Create(ip)
 	assert.Nil(t, iperr)
+	waitForIP(controller, ns.Name, ip.Status.Address)
 
 	svc := &corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
@@ -127,17 +174,87 @@ func TestServiceController(t *testing.T) {
 		sec, _ := blendedset.InwinstackV1().Securities(ns.Name).Get(name, metav1.GetOptions{})
 		if sec != nil {
 			assert.Equal(t, ip.Status.Address, sec.Spec.DestinationAddresses[0])
-			assert.Equal(t, cfg.Services, sec.Spec.Services)
+			assert.Equal(t, []string{name + "-tcp"}, sec.Spec.Services)
 			assert.Equal(t, cfg.DestinationZones, sec.Spec.DestinationZones)
-			assert.Equal(t, []string{"172.22.132.99", "172.22.131.0/32"}, sec.Spec.SourceAddresses)
+			assert.Equal(t, []string{"172.22.131.0", "172.22.132.99"}, sec.Spec.SourceAddresses)
+			assert.Equal(t, constants.ManagedByValue, sec.Labels[constants.ManagedByKey])
+			assert.Equal(t, svc.Name, sec.Labels[constants.ServiceNameKey])
+			assert.Equal(t, svc.Name, sec.OwnerReferences[0].Name)
 			failed = false
 			break
 		}
 	}
 	assert.Equal(t, false, failed, "cannot get Security.")
 
+	obj, err := blendedset.InwinstackV1().Services().Get(name+"-tcp", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "tcp", obj.Spec.Protocol)
+	assert.Equal(t, "80", obj.Spec.DestinationPort)
+
+	_, err = blendedset.InwinstackV1().Services().Get(name+"-udp", metav1.GetOptions{})
+	assert.NotNil(t, err)
+
+	newSvc, err := clientset.CoreV1().Services(ns.Name).Get(svc.Name, metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Contains(t, newSvc.Finalizers, constants.ServiceFinalizer)
+	assert.Equal(t, ip.Status.Address, newSvc.Annotations[constants.SyncedPublicIPKey])
+
+	failed = true
+	for start := time.Now(); time.Since(start) < timeout; {
+		s, _ := clientset.CoreV1().Services(ns.Name).Get(svc.Name, metav1.GetOptions{})
+		status, err := GetSyncStatus(s)
+		assert.Nil(t, err)
+		if status.Phase == SyncPhaseSynced {
+			assert.Equal(t, []string{name}, status.NATs)
+			assert.Equal(t, []string{name}, status.Securities)
+			failed = false
+			break
+		}
+	}
+	assert.Equal(t, false, failed, "cannot get the sync status.")
+
+	assert.Equal(t, fmt.Sprintf("Normal %s Created NAT '%s'", constants.NATCreatedReason, name), <-recorder.Events)
+	assert.Equal(t, fmt.Sprintf("Normal %s Created Security '%s'", constants.SecurityCreatedReason, name), <-recorder.Events)
+
+	// Test for changing the namespace whitelist
+	ns, err = clientset.CoreV1().Namespaces().Get(ns.Name, metav1.GetOptions{})
+	assert.Nil(t, err)
+	ns.Annotations[constants.WhiteListAddressesKey] = "172.22.133.0/24"
+	_, err = clientset.CoreV1().Namespaces().Update(ns)
+	assert.Nil(t, err)
+
+	failed = true
+	for start := time.Now(); time.Since(start) < timeout; {
+		controller.EnqueueServices(ns.Name)
+		sec, _ := blendedset.InwinstackV1().Securities(ns.Name).Get(name, metav1.GetOptions{})
+		if sec != nil && len(sec.Spec.SourceAddresses) == 1 && sec.Spec.SourceAddresses[0] == "172.22.133.0/24" {
+			failed = false
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	assert.Equal(t, false, failed, "cannot update the whitelist of Security.")
+
+	// Test for drifting
+	newSvc, err = clientset.CoreV1().Services(ns.Name).Get(svc.Name, metav1.GetOptions{})
+	assert.Nil(t, err)
+	cfg.LogSettingName = "test-log"
+	newSvc.Spec.ExternalIPs = []string{"172.11.22.44"}
+	_, err = clientset.CoreV1().Services(ns.Name).Update(newSvc)
+	assert.Nil(t, err)
+
+	failed = true
+	for start := time.Now(); time.Since(start) < timeout; {
+		nat, _ := blendedset.InwinstackV1().NATs(ns.Name).Get(name, metav1.GetOptions{})
+		sec, _ := blendedset.InwinstackV1().Securities(ns.Name).Get(name, metav1.GetOptions{})
+		if nat.Spec.DatAddress == "172.11.22.44" && sec.Spec.LogSetting == "test-log" {
+			failed = false
+			break
+		}
+	}
+	assert.Equal(t, false, failed, "cannot update drifted NAT and Security.")
+
 	// Test for deleting
-	newSvc, _ := clientset.CoreV1().Services(ns.Name).Get(svc.Name, metav1.GetOptions{})
 	assert.Nil(t, clientset.CoreV1().Services(ns.Name).Delete(svc.Name, nil))
 
 	controller.cleanup(newSvc)
@@ -150,6 +267,1003 @@ func TestServiceController(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, 0, len(secList.Items))
 
+	objList, err := blendedset.InwinstackV1().Services().List(metav1.ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(objList.Items))
+
+	cancel()
+	controller.Stop()
+}
+
+func TestServiceFinalizer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cfg := &config.Config{
+		Threads:          2,
+		SourceZones:      []string{"untrust"},
+		DestinationZones: []string{"test"},
+	}
+
+	clientset := fake.NewSimpleClientset()
+	blendedset := blendedfake.NewSimpleClientset()
+	informer := informers.NewSharedInformerFactory(clientset, 0)
+	blendedInformer := blendedinformers.NewSharedInformerFactory(blendedset, 0)
+
+	controller := NewController(cfg, clientset, blendedset, informer.Core().V1().Services(), informer.Core().V1().Namespaces(), blendedInformer.Inwinstack().V1().IPs(), blendedInformer.Inwinstack().V1().NATs(), blendedInformer.Inwinstack().V1().Securities(), nil, nil, nil)
+	go informer.Start(ctx.Done())
+	go blendedInformer.Start(ctx.Done())
+	assert.Nil(t, controller.Run(ctx, cfg.Threads))
+
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test2"}}
+	_, nserr := clientset.CoreV1().Namespaces().Create(ns)
+	assert.Nil(t, nserr)
+
+	_, iperr := blendedset.InwinstackV1().IPs(ns.Name).Create(newIP(ns.Name, "172.11.22.34", "140.11.22.34", blendedv1.IPActive))
+	assert.Nil(t, iperr)
+	waitForIP(controller, ns.Name, "140.11.22.34")
+
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-svc",
+			Namespace: ns.Name,
+			Annotations: map[string]string{
+				constants.PublicIPKey: "140.11.22.34",
+			},
+		},
+		Spec: corev1.ServiceSpec{
+			ExternalIPs: []string{"172.11.22.34"},
+			Type:        corev1.ServiceTypeLoadBalancer,
+			Ports: []corev1.ServicePort{
+				{Port: 53, Protocol: corev1.ProtocolUDP},
+			},
+		},
+	}
+	_, svcerr := clientset.CoreV1().Services(svc.Namespace).Create(svc)
+	assert.Nil(t, svcerr)
+
+	name := fmt.Sprintf("%s-%s", constants.PolicyPrefix, "140.11.22.34")
+	failed := true
+	for start := time.Now(); time.Since(start) < timeout; {
+		sec, _ := blendedset.InwinstackV1().Securities(ns.Name).Get(name, metav1.GetOptions{})
+		if sec != nil {
+			failed = false
+			break
+		}
+	}
+	assert.Equal(t, false, failed, "cannot get Security.")
+
+	// The public IP annotation is removed before deleting
+	newSvc, err := clientset.CoreV1().Services(ns.Name).Get(svc.Name, metav1.GetOptions{})
+	assert.Nil(t, err)
+	now := metav1.Now()
+	newSvc.DeletionTimestamp = &now
+	delete(newSvc.Annotations, constants.PublicIPKey)
+	_, err = clientset.CoreV1().Services(ns.Name).Update(newSvc)
+	assert.Nil(t, err)
+
+	failed = true
+	for start := time.Now(); time.Since(start) < timeout; {
+		s, _ := clientset.CoreV1().Services(ns.Name).Get(svc.Name, metav1.GetOptions{})
+		if s != nil && len(s.Finalizers) == 0 {
+			failed = false
+			break
+		}
+	}
+	assert.Equal(t, false, failed, "cannot release the finalizer.")
+
+	natList, err := blendedset.InwinstackV1().NATs(ns.Name).List(metav1.ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(natList.Items))
+
+	secList, err := blendedset.InwinstackV1().Securities(ns.Name).List(metav1.ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(secList.Items))
+
+	cancel()
+	controller.Stop()
+}
+
+func TestServiceMultipleAddresses(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cfg := &config.Config{
+		Threads:          2,
+		SourceZones:      []string{"untrust"},
+		DestinationZones: []string{"test"},
+	}
+
+	clientset := fake.NewSimpleClientset()
+	blendedset := blendedfake.NewSimpleClientset()
+	informer := informers.NewSharedInformerFactory(clientset, 0)
+	blendedInformer := blendedinformers.NewSharedInformerFactory(blendedset, 0)
+
+	controller := NewController(cfg, clientset, blendedset, informer.Core().V1().Services(), informer.Core().V1().Namespaces(), blendedInformer.Inwinstack().V1().IPs(), blendedInformer.Inwinstack().V1().NATs(), blendedInformer.Inwinstack().V1().Securities(), nil, nil, nil)
+	go informer.Start(ctx.Done())
+	go blendedInformer.Start(ctx.Done())
+	assert.Nil(t, controller.Run(ctx, cfg.Threads))
+
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test3"}}
+	_, nserr := clientset.CoreV1().Namespaces().Create(ns)
+	assert.Nil(t, nserr)
+
+	for _, addr := range []string{"35", "36"} {
+		_, iperr := blendedset.InwinstackV1().IPs(ns.Name).Create(newIP(ns.Name, "172.11.22."+addr, "140.11.22."+addr, blendedv1.IPActive))
+		assert.Nil(t, iperr)
+		waitForIP(controller, ns.Name, "140.11.22."+addr)
+	}
+
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-svc",
+			Namespace: ns.Name,
+			Annotations: map[string]string{
+				constants.PublicIPKey: "140.11.22.35,140.11.22.36",
+			},
+		},
+		Spec: corev1.ServiceSpec{
+			ExternalIPs: []string{"172.11.22.35", "172.11.22.36"},
+			Type:        corev1.ServiceTypeLoadBalancer,
+			Ports: []corev1.ServicePort{
+				{Port: 443, Protocol: corev1.ProtocolTCP},
+			},
+		},
+	}
+	_, svcerr := clientset.CoreV1().Services(svc.Namespace).Create(svc)
+	assert.Nil(t, svcerr)
+
+	pairs := map[string]string{"140.11.22.35": "172.11.22.35", "140.11.22.36": "172.11.22.36"}
+	for addr, externalIP := range pairs {
+		name := fmt.Sprintf("%s-%s", constants.PolicyPrefix, addr)
+		failed := true
+		for start := time.Now(); time.Since(start) < timeout; {
+			nat, _ := blendedset.InwinstackV1().NATs(ns.Name).Get(name, metav1.GetOptions{})
+			sec, _ := blendedset.InwinstackV1().Securities(ns.Name).Get(name, metav1.GetOptions{})
+			if nat != nil && sec != nil {
+				assert.Equal(t, externalIP, nat.Spec.DatAddress)
+				assert.Equal(t, []string{addr}, sec.Spec.DestinationAddresses)
+				failed = false
+				break
+			}
+		}
+		assert.Equal(t, false, failed, "cannot get NAT and Security.")
+	}
+
+	// Release the second public IP
+	newSvc, err := clientset.CoreV1().Services(ns.Name).Get(svc.Name, metav1.GetOptions{})
+	assert.Nil(t, err)
+	newSvc.Annotations[constants.PublicIPKey] = "140.11.22.35"
+	newSvc.Spec.ExternalIPs = []string{"172.11.22.35"}
+	_, err = clientset.CoreV1().Services(ns.Name).Update(newSvc)
+	assert.Nil(t, err)
+
+	failed := true
+	for start := time.Now(); time.Since(start) < timeout; {
+		natList, _ := blendedset.InwinstackV1().NATs(ns.Name).List(metav1.ListOptions{})
+		secList, _ := blendedset.InwinstackV1().Securities(ns.Name).List(metav1.ListOptions{})
+		if len(natList.Items) == 1 && len(secList.Items) == 1 {
+			assert.Equal(t, "k8s-140.11.22.35", natList.Items[0].Name)
+			assert.Equal(t, "k8s-140.11.22.35", secList.Items[0].Name)
+			failed = false
+			break
+		}
+	}
+	assert.Equal(t, false, failed, "cannot release the NAT and Security.")
+
+	cancel()
+	controller.Stop()
+}
+
+func TestServiceSharedPublicIP(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cfg := &config.Config{Threads: 2}
+
+	clientset := fake.NewSimpleClientset()
+	blendedset := blendedfake.NewSimpleClientset()
+	informer := informers.NewSharedInformerFactory(clientset, 0)
+	blendedInformer := blendedinformers.NewSharedInformerFactory(blendedset, 0)
+
+	recorder := record.NewFakeRecorder(100)
+	controller := NewController(cfg, clientset, blendedset, informer.Core().V1().Services(), informer.Core().V1().Namespaces(), blendedInformer.Inwinstack().V1().IPs(), blendedInformer.Inwinstack().V1().NATs(), blendedInformer.Inwinstack().V1().Securities(), nil, nil, nil)
+	controller.recorder = recorder
+	go informer.Start(ctx.Done())
+	go blendedInformer.Start(ctx.Done())
+	assert.Nil(t, controller.Run(ctx, cfg.Threads))
+
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test-shared"}}
+	_, nserr := clientset.CoreV1().Namespaces().Create(ns)
+	assert.Nil(t, nserr)
+
+	_, iperr := blendedset.InwinstackV1().IPs(ns.Name).Create(newIP(ns.Name, "172.11.22.60", "140.11.22.60", blendedv1.IPActive))
+	assert.Nil(t, iperr)
+	waitForIP(controller, ns.Name, "140.11.22.60")
+
+	// The Services sharing the public IP list different external IPs
+	for name, externalIP := range map[string]string{"svc-a": "172.11.22.60", "svc-b": "172.11.22.61"} {
+		svc := &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   ns.Name,
+				Annotations: map[string]string{constants.PublicIPKey: "140.11.22.60"},
+			},
+			Spec: corev1.ServiceSpec{
+				ExternalIPs: []string{externalIP},
+				Type:        corev1.ServiceTypeLoadBalancer,
+				Ports:       []corev1.ServicePort{{Port: 80, Protocol: corev1.ProtocolTCP}},
+			},
+		}
+		_, svcerr := clientset.CoreV1().Services(svc.Namespace).Create(svc)
+		assert.Nil(t, svcerr)
+	}
+
+	event := fmt.Sprintf("Warning %s The public IP '140.11.22.60' is paired with the external IP '172.11.22.60' of 'svc-a' instead of '172.11.22.61'", constants.ExternalIPConflictReason)
+	failed := true
+	for start := time.Now(); time.Since(start) < timeout && failed; {
+		select {
+		case e := <-recorder.Events:
+			failed = e != event
+		case <-time.After(timeout):
+		}
+	}
+	assert.Equal(t, false, failed, "cannot get the external IP conflict event.")
+
+	// The NAT always takes the external IP of the first owner
+	failed = true
+	for start := time.Now(); time.Since(start) < timeout; {
+		nat, _ := blendedset.InwinstackV1().NATs(ns.Name).Get(policyName("140.11.22.60"), metav1.GetOptions{})
+		if nat != nil {
+			assert.Equal(t, "172.11.22.60", nat.Spec.DatAddress)
+			failed = false
+			break
+		}
+	}
+	assert.Equal(t, false, failed, "cannot get NAT.")
+
+	// The NAT takes the external IP of the remaining owner when the first owner is released. The workers
+	// are stopped, so that the remaining owner isn't synced by the events of the released objects.
+	controller.Stop()
+	svcA, err := controller.lister.Services(ns.Name).Get("svc-a")
+	assert.Nil(t, err)
+	assert.Nil(t, controller.release("140.11.22.60", svcA))
+
+	nat, err := blendedset.InwinstackV1().NATs(ns.Name).Get(policyName("140.11.22.60"), metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "172.11.22.61", nat.Spec.DatAddress)
+	assert.Equal(t, "svc-b", nat.Labels[constants.ServiceNameKey])
+
+	cancel()
+}
+
+func TestServiceUnsupportedProtocol(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cfg := &config.Config{Threads: 2}
+
+	clientset := fake.NewSimpleClientset()
+	blendedset := blendedfake.NewSimpleClientset()
+	informer := informers.NewSharedInformerFactory(clientset, 0)
+	blendedInformer := blendedinformers.NewSharedInformerFactory(blendedset, 0)
+
+	recorder := record.NewFakeRecorder(100)
+	controller := NewController(cfg, clientset, blendedset, informer.Core().V1().Services(), informer.Core().V1().Namespaces(), blendedInformer.Inwinstack().V1().IPs(), blendedInformer.Inwinstack().V1().NATs(), blendedInformer.Inwinstack().V1().Securities(), nil, nil, nil)
+	controller.recorder = recorder
+	go informer.Start(ctx.Done())
+	go blendedInformer.Start(ctx.Done())
+	assert.Nil(t, controller.Run(ctx, cfg.Threads))
+
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test-sctp"}}
+	_, nserr := clientset.CoreV1().Namespaces().Create(ns)
+	assert.Nil(t, nserr)
+
+	_, iperr := blendedset.InwinstackV1().IPs(ns.Name).Create(newIP(ns.Name, "172.11.22.50", "140.11.22.50", blendedv1.IPActive))
+	assert.Nil(t, iperr)
+	waitForIP(controller, ns.Name, "140.11.22.50")
+
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test-svc",
+			Namespace:   ns.Name,
+			Annotations: map[string]string{constants.PublicIPKey: "140.11.22.50"},
+		},
+		Spec: corev1.ServiceSpec{
+			ExternalIPs: []string{"172.11.22.50"},
+			Type:        corev1.ServiceTypeLoadBalancer,
+			Ports:       []corev1.ServicePort{{Port: 3868, Protocol: corev1.ProtocolSCTP}},
+		},
+	}
+	_, svcerr := clientset.CoreV1().Services(svc.Namespace).Create(svc)
+	assert.Nil(t, svcerr)
+
+	// The Security is skipped, since it would allow all services without any service object
+	name := policyName("140.11.22.50")
+	event := fmt.Sprintf("Warning %s Skipped the SCTP ports of public IP '140.11.22.50' which are not supported", constants.UnsupportedProtocolReason)
+	failed := true
+	for start := time.Now(); time.Since(start) < timeout && failed; {
+		select {
+		case e := <-recorder.Events:
+			failed = e != event
+		case <-time.After(timeout):
+		}
+	}
+	assert.Equal(t, false, failed, "cannot get the unsupported protocol event.")
+
+	failed = true
+	for start := time.Now(); time.Since(start) < timeout; {
+		nat, _ := blendedset.InwinstackV1().NATs(ns.Name).Get(name, metav1.GetOptions{})
+		if nat != nil {
+			failed = false
+			break
+		}
+	}
+	assert.Equal(t, false, failed, "cannot get NAT.")
+
+	_, err := blendedset.InwinstackV1().Securities(ns.Name).Get(name, metav1.GetOptions{})
+	assert.True(t, errors.IsNotFound(err))
+
+	cancel()
+	controller.Stop()
+}
+
+func TestServiceSyncFailed(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cfg := &config.Config{Threads: 2}
+
+	clientset := fake.NewSimpleClientset()
+	blendedset := blendedfake.NewSimpleClientset()
+	informer := informers.NewSharedInformerFactory(clientset, 0)
+	blendedInformer := blendedinformers.NewSharedInformerFactory(blendedset, 0)
+
+	recorder := record.NewFakeRecorder(100)
+	controller := NewController(cfg, clientset, blendedset, informer.Core().V1().Services(), informer.Core().V1().Namespaces(), blendedInformer.Inwinstack().V1().IPs(), blendedInformer.Inwinstack().V1().NATs(), blendedInformer.Inwinstack().V1().Securities(), nil, nil, nil)
+	controller.recorder = recorder
+	go informer.Start(ctx.Done())
+	go blendedInformer.Start(ctx.Done())
+	assert.Nil(t, controller.Run(ctx, cfg.Threads))
+
+	// The ClusterIP service without public IP is skipped without retrying or recording the status
+	unrelated := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "unrelated-svc",
+			Namespace: "test4",
+		},
+		Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeClusterIP},
+	}
+	_, svcerr := clientset.CoreV1().Services(unrelated.Namespace).Create(unrelated)
+	assert.Nil(t, svcerr)
+
+	time.Sleep(time.Millisecond * 100)
+	assert.Equal(t, 0, controller.queue.Len())
+	s, err := clientset.CoreV1().Services(unrelated.Namespace).Get(unrelated.Name, metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Empty(t, s.Annotations[constants.SyncStatusKey])
+	assert.Empty(t, s.Finalizers)
+	assert.Len(t, recorder.Events, 0)
+
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test-svc",
+			Namespace:   "test4",
+			Annotations: map[string]string{constants.PublicIPKey: "invalid"},
+		},
+		Spec: corev1.ServiceSpec{
+			ExternalIPs: []string{"172.11.22.37"},
+			Type:        corev1.ServiceTypeLoadBalancer,
+		},
+	}
+	_, svcerr = clientset.CoreV1().Services(svc.Namespace).Create(svc)
+	assert.Nil(t, svcerr)
+
+	failed := true
+	for start := time.Now(); time.Since(start) < timeout; {
+		s, _ := clientset.CoreV1().Services(svc.Namespace).Get(svc.Name, metav1.GetOptions{})
+		status, err := GetSyncStatus(s)
+		assert.Nil(t, err)
+		if status.Phase == SyncPhaseFailed {
+			assert.Equal(t, "failed to get the public IP", status.Reason)
+			failed = false
+			break
+		}
+	}
+	assert.Equal(t, false, failed, "cannot get the failed sync status.")
+
+	assert.Equal(t, fmt.Sprintf("Warning %s failed to get the public IP", constants.SyncFailedReason), <-recorder.Events)
+
+	cancel()
+	controller.Stop()
+}
+
+func TestServiceInactiveIP(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cfg := &config.Config{
+		Threads:          2,
+		SourceZones:      []string{"untrust"},
+		DestinationZones: []string{"test"},
+	}
+
+	clientset := fake.NewSimpleClientset()
+	blendedset := blendedfake.NewSimpleClientset()
+	informer := informers.NewSharedInformerFactory(clientset, 0)
+	blendedInformer := blendedinformers.NewSharedInformerFactory(blendedset, 0)
+
+	controller := NewController(cfg, clientset, blendedset, informer.Core().V1().Services(), informer.Core().V1().Namespaces(), blendedInformer.Inwinstack().V1().IPs(), blendedInformer.Inwinstack().V1().NATs(), blendedInformer.Inwinstack().V1().Securities(), nil, nil, nil)
+	go informer.Start(ctx.Done())
+	go blendedInformer.Start(ctx.Done())
+	assert.Nil(t, controller.Run(ctx, cfg.Threads))
+
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test6"}}
+	_, nserr := clientset.CoreV1().Namespaces().Create(ns)
+	assert.Nil(t, nserr)
+
+	ip, err := blendedset.InwinstackV1().IPs(ns.Name).Create(newIP(ns.Name, "172.11.22.39", "140.11.22.39", blendedv1.IPFailed))
+	assert.Nil(t, err)
+
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test-svc",
+			Namespace:   ns.Name,
+			Annotations: map[string]string{constants.PublicIPKey: "140.11.22.39"},
+		},
+		Spec: corev1.ServiceSpec{
+			ExternalIPs: []string{"172.11.22.39"},
+			Ports:       []corev1.ServicePort{{Port: 80, Protocol: corev1.ProtocolTCP}},
+		},
+	}
+	_, svcerr := clientset.CoreV1().Services(svc.Namespace).Create(svc)
+	assert.Nil(t, svcerr)
+
+	// The NAT isn't programmed until the IP is active
+	name := fmt.Sprintf("%s-%s", constants.PolicyPrefix, ip.Status.Address)
+	failed := true
+	for start := time.Now(); time.Since(start) < timeout; {
+		s, _ := clientset.CoreV1().Services(svc.Namespace).Get(svc.Name, metav1.GetOptions{})
+		status, err := GetSyncStatus(s)
+		assert.Nil(t, err)
+		if status.Phase == SyncPhaseFailed {
+			assert.Equal(t, "the public IP '140.11.22.39' is not active", status.Reason)
+			failed = false
+			break
+		}
+	}
+	assert.Equal(t, false, failed, "cannot get the failed sync status.")
+
+	_, err = blendedset.InwinstackV1().NATs(ns.Name).Get(name, metav1.GetOptions{})
+	assert.NotNil(t, err)
+
+	_, err = blendedset.InwinstackV1().Securities(ns.Name).Get(name, metav1.GetOptions{})
+	assert.Nil(t, err)
+
+	// The Service is re-synced when the IP becomes active
+	ip.Status.Phase = blendedv1.IPActive
+	_, err = blendedset.InwinstackV1().IPs(ns.Name).Update(ip)
+	assert.Nil(t, err)
+
+	failed = true
+	for start := time.Now(); time.Since(start) < timeout; {
+		nat, _ := blendedset.InwinstackV1().NATs(ns.Name).Get(name, metav1.GetOptions{})
+		if nat != nil {
+			assert.Equal(t, "172.11.22.39", nat.Spec.DatAddress)
+			failed = false
+			break
+		}
+	}
+	assert.Equal(t, false, failed, "cannot get NAT.")
+
+	// The NAT is removed when the IP is released
+	assert.Nil(t, blendedset.InwinstackV1().IPs(ns.Name).Delete(ip.Name, nil))
+
+	failed = true
+	for start := time.Now(); time.Since(start) < timeout; {
+		natList, _ := blendedset.InwinstackV1().NATs(ns.Name).List(metav1.ListOptions{})
+		if natList != nil && len(natList.Items) == 0 {
+			failed = false
+			break
+		}
+	}
+	assert.Equal(t, false, failed, "cannot delete NAT.")
+
+	cancel()
+	controller.Stop()
+}
+
+func TestServiceRestoreObjects(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cfg := &config.Config{
+		Threads:          2,
+		SourceZones:      []string{"untrust"},
+		DestinationZones: []string{"test"},
+	}
+
+	clientset := fake.NewSimpleClientset()
+	blendedset := blendedfake.NewSimpleClientset()
+	informer := informers.NewSharedInformerFactory(clientset, 0)
+	blendedInformer := blendedinformers.NewSharedInformerFactory(blendedset, 0)
+
+	controller := NewController(cfg, clientset, blendedset, informer.Core().V1().Services(), informer.Core().V1().Namespaces(), blendedInformer.Inwinstack().V1().IPs(), blendedInformer.Inwinstack().V1().NATs(), blendedInformer.Inwinstack().V1().Securities(), nil, nil, nil)
+	go informer.Start(ctx.Done())
+	go blendedInformer.Start(ctx.Done())
+	assert.Nil(t, controller.Run(ctx, cfg.Threads))
+
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test7"}}
+	_, nserr := clientset.CoreV1().Namespaces().Create(ns)
+	assert.Nil(t, nserr)
+
+	_, iperr := blendedset.InwinstackV1().IPs(ns.Name).Create(newIP(ns.Name, "172.11.22.40", "140.11.22.40", blendedv1.IPActive))
+	assert.Nil(t, iperr)
+	waitForIP(controller, ns.Name, "140.11.22.40")
+
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test-svc",
+			Namespace:   ns.Name,
+			Annotations: map[string]string{constants.PublicIPKey: "140.11.22.40"},
+		},
+		Spec: corev1.ServiceSpec{
+			ExternalIPs: []string{"172.11.22.40"},
+			Ports:       []corev1.ServicePort{{Port: 80, Protocol: corev1.ProtocolTCP}},
+		},
+	}
+	_, svcerr := clientset.CoreV1().Services(svc.Namespace).Create(svc)
+	assert.Nil(t, svcerr)
+
+	name := fmt.Sprintf("%s-%s", constants.PolicyPrefix, "140.11.22.40")
+	failed := true
+	for start := time.Now(); time.Since(start) < timeout; {
+		nat, _ := blendedset.InwinstackV1().NATs(ns.Name).Get(name, metav1.GetOptions{})
+		sec, _ := blendedset.InwinstackV1().Securities(ns.Name).Get(name, metav1.GetOptions{})
+		if nat != nil && sec != nil {
+			failed = false
+			break
+		}
+	}
+	assert.Equal(t, false, failed, "cannot get NAT and Security.")
+
+	// The Security deleted by hand is created again
+	assert.Nil(t, blendedset.InwinstackV1().Securities(ns.Name).Delete(name, nil))
+
+	failed = true
+	for start := time.Now(); time.Since(start) < timeout; {
+		sec, _ := blendedset.InwinstackV1().Securities(ns.Name).Get(name, metav1.GetOptions{})
+		if sec != nil {
+			failed = false
+			break
+		}
+	}
+	assert.Equal(t, false, failed, "cannot restore the deleted Security.")
+
+	// The NAT edited by hand is restored, the up-to-date objects are never written
+	failed = true
+	for start := time.Now(); time.Since(start) < timeout; {
+		if testutil.ToFloat64(metrics.ObjectUpdates.WithLabelValues(plan.KindNAT, metrics.UpdateSkipped)) > 0 {
+			failed = false
+			break
+		}
+	}
+	assert.Equal(t, false, failed, "cannot count the skipped NAT update.")
+	written := testutil.ToFloat64(metrics.ObjectUpdates.WithLabelValues(plan.KindNAT, metrics.UpdateWritten))
+
+	nat, err := blendedset.InwinstackV1().NATs(ns.Name).Get(name, metav1.GetOptions{})
+	assert.Nil(t, err)
+	nat.Spec.DatAddress = "172.11.22.99"
+	_, err = blendedset.InwinstackV1().NATs(ns.Name).Update(nat)
+	assert.Nil(t, err)
+
+	failed = true
+	for start := time.Now(); time.Since(start) < timeout; {
+		n, _ := blendedset.InwinstackV1().NATs(ns.Name).Get(name, metav1.GetOptions{})
+		if n != nil && n.Spec.DatAddress == "172.11.22.40" {
+			failed = false
+			break
+		}
+	}
+	assert.Equal(t, false, failed, "cannot restore the edited NAT.")
+
+	failed = true
+	for start := time.Now(); time.Since(start) < timeout; {
+		if testutil.ToFloat64(metrics.ObjectUpdates.WithLabelValues(plan.KindNAT, metrics.UpdateWritten)) > written {
+			failed = false
+			break
+		}
+	}
+	assert.Equal(t, false, failed, "cannot count the written NAT update.")
+
+	cancel()
+	controller.Stop()
+}
+
+func TestServiceDualStack(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cfg := &config.Config{
+		Threads:          2,
+		SourceZones:      []string{"untrust"},
+		DestinationZones: []string{"test"},
+	}
+
+	clientset := fake.NewSimpleClientset()
+	blendedset := blendedfake.NewSimpleClientset()
+	informer := informers.NewSharedInformerFactory(clientset, 0)
+	blendedInformer := blendedinformers.NewSharedInformerFactory(blendedset, 0)
+
+	controller := NewController(cfg, clientset, blendedset, informer.Core().V1().Services(), informer.Core().V1().Namespaces(), blendedInformer.Inwinstack().V1().IPs(), blendedInformer.Inwinstack().V1().NATs(), blendedInformer.Inwinstack().V1().Securities(), nil, nil, nil)
+	go informer.Start(ctx.Done())
+	go blendedInformer.Start(ctx.Done())
+	assert.Nil(t, controller.Run(ctx, cfg.Threads))
+
+	ns := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test8",
+			Annotations: map[string]string{constants.WhiteListAddressesKey: "172.22.132.99,2001:db8:1::/48"},
+		},
+	}
+	_, nserr := clientset.CoreV1().Namespaces().Create(ns)
+	assert.Nil(t, nserr)
+
+	_, iperr := blendedset.InwinstackV1().IPs(ns.Name).Create(newIP(ns.Name, "172.11.22.41", "140.11.22.41", blendedv1.IPActive))
+	assert.Nil(t, iperr)
+	waitForIP(controller, ns.Name, "140.11.22.41")
+
+	_, iperr = blendedset.InwinstackV1().IPs(ns.Name).Create(newIP(ns.Name, "fd00::41", "2001:db8::41", blendedv1.IPActive))
+	assert.Nil(t, iperr)
+	waitForIP(controller, ns.Name, "2001:db8::41")
+
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test-svc",
+			Namespace:   ns.Name,
+			Annotations: map[string]string{constants.PublicIPKey: "2001:db8::41,140.11.22.41"},
+		},
+		Spec: corev1.ServiceSpec{
+			ExternalIPs: []string{"172.11.22.41", "fd00::41"},
+			Ports:       []corev1.ServicePort{{Port: 80, Protocol: corev1.ProtocolTCP}},
+		},
+	}
+	_, svcerr := clientset.CoreV1().Services(svc.Namespace).Create(svc)
+	assert.Nil(t, svcerr)
+
+	tests := []struct {
+		Name       string
+		Type       string
+		DatAddress string
+		Sources    []string
+	}{
+		{Name: "k8s-140.11.22.41", Type: blendedv1.NATIPv4, DatAddress: "172.11.22.41", Sources: []string{"172.22.132.99"}},
+		{Name: "k8s-2001-0db8-0000-0000-0000-0000-0000-0041", Type: blendedv1.NATIPv6, DatAddress: "fd00::41", Sources: []string{"2001:db8:1::/48"}},
+	}
+
+	for _, test := range tests {
+		failed := true
+		for start := time.Now(); time.Since(start) < timeout; {
+			nat, _ := blendedset.InwinstackV1().NATs(ns.Name).Get(test.Name, metav1.GetOptions{})
+			sec, _ := blendedset.InwinstackV1().Securities(ns.Name).Get(test.Name, metav1.GetOptions{})
+			if nat != nil && sec != nil {
+				assert.Equal(t, test.Type, nat.Spec.Type)
+				assert.Equal(t, test.DatAddress, nat.Spec.DatAddress)
+				assert.Equal(t, test.Sources, sec.Spec.SourceAddresses)
+				failed = false
+				break
+			}
+		}
+		assert.Equal(t, false, failed, "cannot get NAT and Security of '%s'.", test.Name)
+	}
+
+	cancel()
+	controller.Stop()
+}
+
+func TestServiceUnmanaged(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cfg := &config.Config{
+		Threads:          2,
+		SourceZones:      []string{"untrust"},
+		DestinationZones: []string{"test"},
+		ServiceTypes:     []string{string(corev1.ServiceTypeLoadBalancer)},
+		OptInKey:         constants.SyncOptInKey,
+	}
+
+	clientset := fake.NewSimpleClientset()
+	blendedset := blendedfake.NewSimpleClientset()
+	informer := informers.NewSharedInformerFactory(clientset, 0)
+	blendedInformer := blendedinformers.NewSharedInformerFactory(blendedset, 0)
+
+	controller := NewController(cfg, clientset, blendedset, informer.Core().V1().Services(), informer.Core().V1().Namespaces(), blendedInformer.Inwinstack().V1().IPs(), blendedInformer.Inwinstack().V1().NATs(), blendedInformer.Inwinstack().V1().Securities(), nil, nil, nil)
+	go informer.Start(ctx.Done())
+	go blendedInformer.Start(ctx.Done())
+	assert.Nil(t, controller.Run(ctx, cfg.Threads))
+
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test9"}}
+	_, nserr := clientset.CoreV1().Namespaces().Create(ns)
+	assert.Nil(t, nserr)
+
+	_, iperr := blendedset.InwinstackV1().IPs(ns.Name).Create(newIP(ns.Name, "172.11.22.42", "140.11.22.42", blendedv1.IPActive))
+	assert.Nil(t, iperr)
+	waitForIP(controller, ns.Name, "140.11.22.42")
+
+	// The ClusterIP service isn't synced
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test-svc",
+			Namespace:   ns.Name,
+			Annotations: map[string]string{constants.PublicIPKey: "140.11.22.42"},
+		},
+		Spec: corev1.ServiceSpec{
+			ExternalIPs: []string{"172.11.22.42"},
+			Type:        corev1.ServiceTypeClusterIP,
+			Ports:       []corev1.ServicePort{{Port: 80, Protocol: corev1.ProtocolTCP}},
+		},
+	}
+	_, svcerr := clientset.CoreV1().Services(svc.Namespace).Create(svc)
+	assert.Nil(t, svcerr)
+
+	time.Sleep(time.Millisecond * 100)
+	assert.Equal(t, 0, controller.queue.Len())
+	s, err := clientset.CoreV1().Services(svc.Namespace).Get(svc.Name, metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Empty(t, s.Annotations[constants.SyncStatusKey])
+
+	// The service is synced after opting in
+	s.Annotations[constants.SyncOptInKey] = "true"
+	_, err = clientset.CoreV1().Services(svc.Namespace).Update(s)
+	assert.Nil(t, err)
+
+	name := fmt.Sprintf("%s-%s", constants.PolicyPrefix, "140.11.22.42")
+	failed := true
+	for start := time.Now(); time.Since(start) < timeout; {
+		nat, _ := blendedset.InwinstackV1().NATs(ns.Name).Get(name, metav1.GetOptions{})
+		if nat != nil {
+			failed = false
+			break
+		}
+	}
+	assert.Equal(t, false, failed, "cannot get NAT.")
+
+	// The service is released after opting out
+	failed = true
+	for start := time.Now(); time.Since(start) < timeout; {
+		s, _ = clientset.CoreV1().Services(svc.Namespace).Get(svc.Name, metav1.GetOptions{})
+		if len(s.Finalizers) > 0 {
+			failed = false
+			break
+		}
+	}
+	assert.Equal(t, false, failed, "cannot get the finalizer.")
+
+	s.Annotations[constants.SyncOptInKey] = "false"
+	_, err = clientset.CoreV1().Services(svc.Namespace).Update(s)
+	assert.Nil(t, err)
+
+	failed = true
+	for start := time.Now(); time.Since(start) < timeout; {
+		s, _ = clientset.CoreV1().Services(svc.Namespace).Get(svc.Name, metav1.GetOptions{})
+		natList, _ := blendedset.InwinstackV1().NATs(ns.Name).List(metav1.ListOptions{})
+		if len(s.Finalizers) == 0 && natList != nil && len(natList.Items) == 0 {
+			assert.Empty(t, s.Annotations[constants.SyncedPublicIPKey])
+			assert.Empty(t, s.Annotations[constants.SyncStatusKey])
+			failed = false
+			break
+		}
+	}
+	assert.Equal(t, false, failed, "cannot release the unmanaged service.")
+
+	cancel()
+	controller.Stop()
+}
+
+func TestServiceFinalizedByPreviousVersion(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cfg := &config.Config{
+		Threads:          2,
+		SourceZones:      []string{"untrust"},
+		DestinationZones: []string{"test"},
+		OptInKey:         constants.SyncOptInKey,
+	}
+
+	// The ClusterIP service was synced by the previous version, which had no service types
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test-previous"}}
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-svc",
+			Namespace: ns.Name,
+			Annotations: map[string]string{
+				constants.PublicIPKey:       "140.11.22.70",
+				constants.SyncedPublicIPKey: "140.11.22.70",
+			},
+			Finalizers: []string{constants.ServiceFinalizer},
+		},
+		Spec: corev1.ServiceSpec{
+			ExternalIPs: []string{"172.11.22.70"},
+			Type:        corev1.ServiceTypeClusterIP,
+			Ports:       []corev1.ServicePort{{Port: 80, Protocol: corev1.ProtocolTCP}},
+		},
+	}
+	name := fmt.Sprintf("%s-%s", constants.PolicyPrefix, "140.11.22.70")
+	clientset := fake.NewSimpleClientset(ns, svc)
+	blendedset := blendedfake.NewSimpleClientset(
+		newIP(ns.Name, "172.11.22.70", "140.11.22.70", blendedv1.IPActive),
+		&blendedv1.NAT{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ns.Name}, Spec: blendedv1.NATSpec{Description: natDescription}},
+		&blendedv1.Security{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ns.Name}, Spec: blendedv1.SecuritySpec{Description: securityDescription}},
+	)
+	informer := informers.NewSharedInformerFactory(clientset, 0)
+	blendedInformer := blendedinformers.NewSharedInformerFactory(blendedset, 0)
+
+	getter := newSwappedConfig(cfg)
+	controller := NewController(getter, clientset, blendedset, informer.Core().V1().Services(), informer.Core().V1().Namespaces(), blendedInformer.Inwinstack().V1().IPs(), blendedInformer.Inwinstack().V1().NATs(), blendedInformer.Inwinstack().V1().Securities(), nil, nil, nil)
+	go informer.Start(ctx.Done())
+	go blendedInformer.Start(ctx.Done())
+	assert.Nil(t, controller.Run(ctx, cfg.Threads))
+
+	// The service is kept with the default flags, and its objects are adopted
+	failed := true
+	for start := time.Now(); time.Since(start) < timeout; {
+		nat, _ := blendedset.InwinstackV1().NATs(ns.Name).Get(name, metav1.GetOptions{})
+		if nat != nil && nat.Labels[constants.ManagedByKey] == constants.ManagedByValue {
+			assert.Equal(t, "172.11.22.70", nat.Spec.DatAddress)
+			failed = false
+			break
+		}
+	}
+	assert.Equal(t, false, failed, "cannot adopt NAT.")
+
+	// Limiting the service types doesn't release the synced service
+	limited := cfg.DeepCopy()
+	limited.ServiceTypes = []string{string(corev1.ServiceTypeLoadBalancer)}
+	getter.Set(limited)
+	controller.Resync()
+	time.Sleep(time.Millisecond * 200)
+
+	s, err := clientset.CoreV1().Services(svc.Namespace).Get(svc.Name, metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{constants.ServiceFinalizer}, s.Finalizers)
+	assert.Equal(t, "140.11.22.70", s.Annotations[constants.SyncedPublicIPKey])
+	_, err = blendedset.InwinstackV1().NATs(ns.Name).Get(name, metav1.GetOptions{})
+	assert.Nil(t, err)
+	_, err = blendedset.InwinstackV1().Securities(ns.Name).Get(name, metav1.GetOptions{})
+	assert.Nil(t, err)
+
+	cancel()
+	controller.Stop()
+}
+
+func TestServiceNamespaceSelector(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cfg := &config.Config{
+		Threads:           2,
+		SourceZones:       []string{"untrust"},
+		DestinationZones:  []string{"test"},
+		NamespaceSelector: "tenant=true",
+	}
+
+	clientset := fake.NewSimpleClientset()
+	blendedset := blendedfake.NewSimpleClientset()
+	informer := informers.NewSharedInformerFactory(clientset, 0)
+	blendedInformer := blendedinformers.NewSharedInformerFactory(blendedset, 0)
+
+	controller := NewController(cfg, clientset, blendedset, informer.Core().V1().Services(), informer.Core().V1().Namespaces(), blendedInformer.Inwinstack().V1().IPs(), blendedInformer.Inwinstack().V1().NATs(), blendedInformer.Inwinstack().V1().Securities(), nil, nil, nil)
+	go informer.Start(ctx.Done())
+	go blendedInformer.Start(ctx.Done())
+	assert.Nil(t, controller.Run(ctx, cfg.Threads))
+
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test10"}}
+	_, nserr := clientset.CoreV1().Namespaces().Create(ns)
+	assert.Nil(t, nserr)
+
+	_, iperr := blendedset.InwinstackV1().IPs(ns.Name).Create(newIP(ns.Name, "172.11.22.43", "140.11.22.43", blendedv1.IPActive))
+	assert.Nil(t, iperr)
+	waitForIP(controller, ns.Name, "140.11.22.43")
+
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test-svc",
+			Namespace:   ns.Name,
+			Annotations: map[string]string{constants.PublicIPKey: "140.11.22.43"},
+		},
+		Spec: corev1.ServiceSpec{
+			ExternalIPs: []string{"172.11.22.43"},
+			Ports:       []corev1.ServicePort{{Port: 80, Protocol: corev1.ProtocolTCP}},
+		},
+	}
+	_, svcerr := clientset.CoreV1().Services(svc.Namespace).Create(svc)
+	assert.Nil(t, svcerr)
+
+	// The service of the namespace which isn't selected is skipped
+	time.Sleep(time.Millisecond * 100)
+	natList, err := blendedset.InwinstackV1().NATs(ns.Name).List(metav1.ListOptions{})
+	assert.Nil(t, err)
+	assert.Len(t, natList.Items, 0)
+
+	// The service is synced once the namespace is labeled
+	ns.Labels = map[string]string{"tenant": "true"}
+	_, err = clientset.CoreV1().Namespaces().Update(ns)
+	assert.Nil(t, err)
+
+	name := fmt.Sprintf("%s-%s", constants.PolicyPrefix, "140.11.22.43")
+	failed := true
+	for start := time.Now(); time.Since(start) < timeout; {
+		nat, _ := blendedset.InwinstackV1().NATs(ns.Name).Get(name, metav1.GetOptions{})
+		if nat != nil {
+			failed = false
+			break
+		}
+	}
+	assert.Equal(t, false, failed, "cannot get NAT.")
+
+	cancel()
+	controller.Stop()
+}
+
+func TestServiceDryRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cfg := &config.Config{Threads: 2, DryRun: true}
+
+	clientset := fake.NewSimpleClientset()
+	blendedset := blendedfake.NewSimpleClientset()
+	informer := informers.NewSharedInformerFactory(clientset, 0)
+	blendedInformer := blendedinformers.NewSharedInformerFactory(blendedset, 0)
+
+	changes := plan.New()
+	controller := NewController(cfg, clientset, blendedset, informer.Core().V1().Services(), informer.Core().V1().Namespaces(), blendedInformer.Inwinstack().V1().IPs(), blendedInformer.Inwinstack().V1().NATs(), blendedInformer.Inwinstack().V1().Securities(), nil, nil, changes)
+	go informer.Start(ctx.Done())
+	go blendedInformer.Start(ctx.Done())
+	assert.Nil(t, controller.Run(ctx, cfg.Threads))
+
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test5"}}
+	_, nserr := clientset.CoreV1().Namespaces().Create(ns)
+	assert.Nil(t, nserr)
+
+	_, iperr := blendedset.InwinstackV1().IPs(ns.Name).Create(newIP(ns.Name, "172.11.22.38", "140.145.20.10", blendedv1.IPActive))
+	assert.Nil(t, iperr)
+	waitForIP(controller, ns.Name, "140.145.20.10")
+
+	// The existing NAT has drifted
+	nat := controller.newNAT("k8s-140.145.20.10", "140.145.20.10", "172.11.22.38", nil, nil, &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: ns.Name}})
+	nat.Spec.DatAddress = "172.11.22.99"
+	_, naterr := blendedset.InwinstackV1().NATs(ns.Name).Create(nat)
+	assert.Nil(t, naterr)
+
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test-svc",
+			Namespace:   ns.Name,
+			Annotations: map[string]string{constants.PublicIPKey: "140.145.20.10"},
+		},
+		Spec: corev1.ServiceSpec{
+			ExternalIPs: []string{"172.11.22.38"},
+			Ports:       []corev1.ServicePort{{Port: 80, Protocol: corev1.ProtocolTCP}},
+		},
+	}
+	_, svcerr := clientset.CoreV1().Services(svc.Namespace).Create(svc)
+	assert.Nil(t, svcerr)
+
+	failed := true
+	for start := time.Now(); time.Since(start) < timeout; {
+		if len(changes.Changes()) == 3 {
+			failed = false
+			break
+		}
+	}
+	assert.Equal(t, false, failed, "failed to plan the changes.")
+
+	actions := map[string]plan.Action{}
+	for _, change := range changes.Changes() {
+		actions[change.Kind+"/"+change.Name] = change.Action
+		if change.Kind == plan.KindNAT {
+			assert.Contains(t, change.Diff, plan.FieldDiff{Field: "DatAddress", Current: "172.11.22.99", Desired: "172.11.22.38"})
+		}
+	}
+	assert.Equal(t, map[string]plan.Action{
+		"NAT/k8s-140.145.20.10":         plan.ActionUpdate,
+		"Security/k8s-140.145.20.10":    plan.ActionCreate,
+		"Service/k8s-140.145.20.10-tcp": plan.ActionCreate,
+	}, actions)
+
+	// Nothing is written in dry-run mode
+	n, err := blendedset.InwinstackV1().NATs(ns.Name).Get(nat.Name, metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "172.11.22.99", n.Spec.DatAddress)
+
+	secs, err := blendedset.InwinstackV1().Securities(ns.Name).List(metav1.ListOptions{})
+	assert.Nil(t, err)
+	assert.Len(t, secs.Items, 0)
+
+	s, err := clientset.CoreV1().Services(svc.Namespace).Get(svc.Name, metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Len(t, s.Finalizers, 0)
+	assert.Empty(t, s.Annotations[constants.SyncStatusKey])
+
 	cancel()
 	controller.Stop()
 }
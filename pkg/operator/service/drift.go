@@ -0,0 +1,112 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"reflect"
+
+	blendedv1 "github.com/inwinstack/blended/apis/inwinstack/v1"
+	"github.com/inwinstack/pa-svc-syncker/pkg/plan"
+)
+
+// drift copies the desired values of the fields owned by the controller to the current object, and
+// records the drifted fields
+type drift struct {
+	diffs []plan.FieldDiff
+}
+
+// fields returns the names of drifted fields
+func (d *drift) fields() []string {
+	fields := []string{}
+	for _, diff := range d.diffs {
+		fields = append(fields, diff.Field)
+	}
+	return fields
+}
+
+func (d *drift) syncString(field string, current *string, desired string) {
+	if *current == desired {
+		return
+	}
+	d.diffs = append(d.diffs, plan.FieldDiff{Field: field, Current: *current, Desired: desired})
+	*current = desired
+}
+
+// syncStrings treats the nil and empty lists as equal
+func (d *drift) syncStrings(field string, current *[]string, desired []string) {
+	if (len(*current) == 0 && len(desired) == 0) || reflect.DeepEqual(*current, desired) {
+		return
+	}
+	d.diffs = append(d.diffs, plan.FieldDiff{Field: field, Current: *current, Desired: desired})
+	*current = desired
+}
+
+func (d *drift) syncBool(field string, current *bool, desired bool) {
+	if *current == desired {
+		return
+	}
+	d.diffs = append(d.diffs, plan.FieldDiff{Field: field, Current: *current, Desired: desired})
+	*current = desired
+}
+
+// syncNATSpec syncs the NATSpec fields which are owned by the controller
+func syncNATSpec(current, desired *blendedv1.NATSpec) *drift {
+	d := &drift{}
+	d.syncString("Type", &current.Type, desired.Type)
+	d.syncString("Description", &current.Description, desired.Description)
+	d.syncStrings("SourceZones", &current.SourceZones, desired.SourceZones)
+	d.syncStrings("SourceAddresses", &current.SourceAddresses, desired.SourceAddresses)
+	d.syncStrings("DestinationAddresses", &current.DestinationAddresses, desired.DestinationAddresses)
+	d.syncString("DestinationZone", &current.DestinationZone, desired.DestinationZone)
+	d.syncString("ToInterface", &current.ToInterface, desired.ToInterface)
+	d.syncString("Service", &current.Service, desired.Service)
+	d.syncString("SatType", &current.SatType, desired.SatType)
+	d.syncString("DatType", &current.DatType, desired.DatType)
+	d.syncString("DatAddress", &current.DatAddress, desired.DatAddress)
+	return d
+}
+
+// syncSecuritySpec syncs the SecuritySpec fields which are owned by the controller
+func syncSecuritySpec(current, desired *blendedv1.SecuritySpec) *drift {
+	d := &drift{}
+	d.syncString("Description", &current.Description, desired.Description)
+	d.syncStrings("SourceZones", &current.SourceZones, desired.SourceZones)
+	d.syncStrings("SourceAddresses", &current.SourceAddresses, desired.SourceAddresses)
+	d.syncStrings("SourceUsers", &current.SourceUsers, desired.SourceUsers)
+	d.syncStrings("HipProfiles", &current.HipProfiles, desired.HipProfiles)
+	d.syncStrings("DestinationZones", &current.DestinationZones, desired.DestinationZones)
+	d.syncStrings("DestinationAddresses", &current.DestinationAddresses, desired.DestinationAddresses)
+	d.syncStrings("Applications", &current.Applications, desired.Applications)
+	d.syncStrings("Services", &current.Services, desired.Services)
+	d.syncStrings("Categories", &current.Categories, desired.Categories)
+	d.syncString("Action", &current.Action, desired.Action)
+	d.syncBool("IcmpUnreachable", &current.IcmpUnreachable, desired.IcmpUnreachable)
+	d.syncBool("DisableServerResponseInspection", &current.DisableServerResponseInspection, desired.DisableServerResponseInspection)
+	d.syncBool("LogEnd", &current.LogEnd, desired.LogEnd)
+	d.syncString("LogSetting", &current.LogSetting, desired.LogSetting)
+	d.syncString("Group", &current.Group, desired.Group)
+	return d
+}
+
+// syncObjectSpec syncs the ServiceSpec fields which are owned by the controller
+func syncObjectSpec(current, desired *blendedv1.ServiceSpec) *drift {
+	d := &drift{}
+	d.syncString("Description", &current.Description, desired.Description)
+	d.syncString("Protocol", &current.Protocol, desired.Protocol)
+	d.syncString("DestinationPort", &current.DestinationPort, desired.DestinationPort)
+	return d
+}
@@ -0,0 +1,80 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"testing"
+
+	blendedv1 "github.com/inwinstack/blended/apis/inwinstack/v1"
+	"github.com/inwinstack/pa-svc-syncker/pkg/plan"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyncSecuritySpec(t *testing.T) {
+	tests := []struct {
+		Current  *blendedv1.SecuritySpec
+		Desired  *blendedv1.SecuritySpec
+		Drifted  []string
+		Expected *blendedv1.SecuritySpec
+	}{
+		{
+			Current:  &blendedv1.SecuritySpec{SourceZones: []string{"untrust"}, LogSetting: "log"},
+			Desired:  &blendedv1.SecuritySpec{SourceZones: []string{"untrust"}, LogSetting: "log"},
+			Drifted:  []string{},
+			Expected: &blendedv1.SecuritySpec{SourceZones: []string{"untrust"}, LogSetting: "log"},
+		},
+		{
+			Current:  &blendedv1.SecuritySpec{SourceZones: []string{}},
+			Desired:  &blendedv1.SecuritySpec{SourceZones: nil},
+			Drifted:  []string{},
+			Expected: &blendedv1.SecuritySpec{SourceZones: []string{}},
+		},
+		{
+			Current:  &blendedv1.SecuritySpec{SourceZones: []string{"untrust"}, LogSetting: "log", Virus: "default"},
+			Desired:  &blendedv1.SecuritySpec{SourceZones: []string{"trust"}, LogSetting: "new-log"},
+			Drifted:  []string{"SourceZones", "LogSetting"},
+			Expected: &blendedv1.SecuritySpec{SourceZones: []string{"trust"}, LogSetting: "new-log", Virus: "default"},
+		},
+	}
+
+	for _, test := range tests {
+		drift := syncSecuritySpec(test.Current, test.Desired)
+		assert.Equal(t, test.Drifted, drift.fields())
+		assert.Equal(t, test.Expected, test.Current)
+	}
+}
+
+func TestSyncNATSpec(t *testing.T) {
+	current := &blendedv1.NATSpec{DatAddress: "172.11.22.99", DatPort: 8080, SourceZones: []string{"untrust"}}
+	desired := &blendedv1.NATSpec{DatAddress: "172.11.22.33", SourceZones: []string{"untrust"}}
+
+	drift := syncNATSpec(current, desired)
+	assert.Equal(t, []string{"DatAddress"}, drift.fields())
+	assert.Equal(t, plan.FieldDiff{Field: "DatAddress", Current: "172.11.22.99", Desired: "172.11.22.33"}, drift.diffs[0])
+	assert.Equal(t, "172.11.22.33", current.DatAddress)
+	assert.Equal(t, int32(8080), current.DatPort)
+	assert.Empty(t, syncNATSpec(current, desired).fields())
+}
+
+func TestSyncObjectSpec(t *testing.T) {
+	current := &blendedv1.ServiceSpec{Protocol: "tcp", DestinationPort: "80", Tags: []string{"web"}}
+	desired := &blendedv1.ServiceSpec{Protocol: "tcp", DestinationPort: "80,443"}
+
+	drift := syncObjectSpec(current, desired)
+	assert.Equal(t, []string{"DestinationPort"}, drift.fields())
+	assert.Equal(t, &blendedv1.ServiceSpec{Protocol: "tcp", DestinationPort: "80,443", Tags: []string{"web"}}, current)
+}
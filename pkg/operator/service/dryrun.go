@@ -14,23 +14,17 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package namespace
+package service
 
 import (
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"github.com/inwinstack/pa-svc-syncker/pkg/plan"
 )
 
-func (c *Controller) updateSecurity(namespace string, sourceAddresses []string) error {
-	secs, err := c.blendedset.InwinstackV1().Securities(namespace).List(metav1.ListOptions{})
-	if err != nil {
-		return err
+// dryRun records the change into the plan instead of applying it if the dry-run mode is enabled
+func (c *Controller) dryRun(change *plan.Change) bool {
+	if c.plan == nil {
+		return false
 	}
-
-	for _, sec := range secs.Items {
-		sec.Spec.SourceAddresses = sourceAddresses
-		if _, err := c.blendedset.InwinstackV1().Securities(namespace).Update(&sec); err != nil {
-			return err
-		}
-	}
-	return nil
+	c.plan.Record(change)
+	return true
 }
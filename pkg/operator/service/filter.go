@@ -0,0 +1,102 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"reflect"
+	"strconv"
+
+	"github.com/inwinstack/pa-svc-syncker/pkg/config"
+	"github.com/inwinstack/pa-svc-syncker/pkg/constants"
+	"github.com/thoas/go-funk"
+	v1 "k8s.io/api/core/v1"
+)
+
+// IsManaged checks whether the service should be synced. The service must have the public IP annotation or
+// the finalizer, otherwise it has nothing to sync or release. The service must match the service selector, then
+// the opt-in label or annotation takes precedence, "true" opts in and "false" opts out, otherwise the service
+// is managed if its type is configured. The empty types mean all types. The service which has been synced,
+// i.e. it has the finalizer, is kept managed regardless of its type, so changing the types never releases
+// the NAT and Security of existing services.
+func IsManaged(cfg *config.Config, svc *v1.Service) bool {
+	synced := funk.ContainsString(svc.Finalizers, constants.ServiceFinalizer)
+	if svc.Annotations[constants.PublicIPKey] == "" && !synced {
+		return false
+	}
+
+	if !cfg.SelectsService(svc) {
+		return false
+	}
+
+	if cfg.OptInKey != "" {
+		for _, values := range []map[string]string{svc.Labels, svc.Annotations} {
+			if value, ok := values[cfg.OptInKey]; ok {
+				if optIn, err := strconv.ParseBool(value); err == nil {
+					return optIn
+				}
+			}
+		}
+	}
+
+	if len(cfg.ServiceTypes) == 0 || synced {
+		return true
+	}
+
+	t := svc.Spec.Type
+	if t == "" {
+		t = v1.ServiceTypeClusterIP
+	}
+	return funk.ContainsString(cfg.ServiceTypes, string(t))
+}
+
+// isSelected checks whether the namespace of service is selected and the service is managed. It returns
+// an error if the namespace isn't in the cache yet.
+func (c *Controller) isSelected(svc *v1.Service) (bool, error) {
+	cfg := c.cfg.Get()
+	if cfg.IgnoresNamespace(svc.Namespace) || !IsManaged(cfg, svc) {
+		return false, nil
+	}
+
+	if cfg.NamespaceSelector == "" {
+		return true, nil
+	}
+
+	ns, err := c.nsLister.Get(svc.Namespace)
+	if err != nil {
+		return false, err
+	}
+	return cfg.SelectsNamespace(ns), nil
+}
+
+// enqueueNamespace enqueues the services of namespace when the namespace is added or its labels changed,
+// so that the services are synced or released once the namespace is selected or deselected
+func (c *Controller) enqueueNamespace(old, new interface{}) {
+	if c.cfg.Get().NamespaceSelector == "" {
+		return
+	}
+
+	ns, ok := new.(*v1.Namespace)
+	if !ok {
+		return
+	}
+
+	if oldNs, ok := old.(*v1.Namespace); ok && reflect.DeepEqual(oldNs.Labels, ns.Labels) {
+		return
+	}
+
+	c.EnqueueServices(ns.Name)
+}
@@ -0,0 +1,80 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"testing"
+
+	"github.com/inwinstack/pa-svc-syncker/pkg/config"
+	"github.com/inwinstack/pa-svc-syncker/pkg/constants"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestIsManaged(t *testing.T) {
+	cfg := &config.Config{
+		ServiceTypes: []string{"LoadBalancer"},
+		OptInKey:     constants.SyncOptInKey,
+	}
+
+	tests := []struct {
+		Type        corev1.ServiceType
+		Labels      map[string]string
+		Annotations map[string]string
+		Finalizers  []string
+		Expected    bool
+	}{
+		{Type: corev1.ServiceTypeLoadBalancer, Expected: true},
+		{Type: corev1.ServiceTypeClusterIP, Expected: false},
+		{Type: "", Expected: false},
+		{Type: corev1.ServiceTypeClusterIP, Labels: map[string]string{constants.SyncOptInKey: "true"}, Expected: true},
+		{Type: corev1.ServiceTypeClusterIP, Annotations: map[string]string{constants.SyncOptInKey: "true"}, Expected: true},
+		{Type: corev1.ServiceTypeLoadBalancer, Annotations: map[string]string{constants.SyncOptInKey: "false"}, Expected: false},
+		{Type: corev1.ServiceTypeLoadBalancer, Annotations: map[string]string{constants.SyncOptInKey: "maybe"}, Expected: true},
+		// The synced service is kept managed after the types are changed, unless it opts out
+		{Type: corev1.ServiceTypeClusterIP, Finalizers: []string{constants.ServiceFinalizer}, Expected: true},
+		{Type: corev1.ServiceTypeClusterIP, Annotations: map[string]string{constants.SyncOptInKey: "false"}, Finalizers: []string{constants.ServiceFinalizer}, Expected: false},
+	}
+
+	for _, test := range tests {
+		annotations := map[string]string{constants.PublicIPKey: "140.11.22.33"}
+		for key, value := range test.Annotations {
+			annotations[key] = value
+		}
+
+		svc := &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Labels: test.Labels, Annotations: annotations, Finalizers: test.Finalizers},
+			Spec:       corev1.ServiceSpec{Type: test.Type},
+		}
+		assert.Equal(t, test.Expected, IsManaged(cfg, svc))
+	}
+
+	// The empty types mean all types
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{constants.PublicIPKey: "140.11.22.33"}},
+	}
+	assert.True(t, IsManaged(&config.Config{}, svc))
+
+	// The service without public IP is skipped, unless it has been synced and needs to be released
+	svc = &corev1.Service{Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeClusterIP}}
+	assert.False(t, IsManaged(&config.Config{}, svc))
+	svc.Labels = map[string]string{constants.SyncOptInKey: "true"}
+	assert.False(t, IsManaged(&config.Config{OptInKey: constants.SyncOptInKey}, svc))
+	svc.Finalizers = []string{constants.ServiceFinalizer}
+	assert.True(t, IsManaged(&config.Config{}, svc))
+}
@@ -0,0 +1,203 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/inwinstack/pa-svc-syncker/pkg/constants"
+	"github.com/inwinstack/pa-svc-syncker/pkg/metrics"
+	"github.com/inwinstack/pa-svc-syncker/pkg/plan"
+	"github.com/thoas/go-funk"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+)
+
+// orphan is a managed object whose public IP isn't used by any service
+type orphan struct {
+	kind      string
+	namespace string
+	name      string
+	address   string
+	delete    func() error
+}
+
+// collectGarbage deletes the managed NATs, Securities and service objects whose public IP isn't used by
+// any service, which happens when services were deleted while syncker was down. In report-only mode,
+// the orphans are only logged and exposed by metrics.
+//
+// Only the labeled objects are collected. The objects which were created by the older version have no
+// labels, they are adopted and labeled when their services are synced, so the unlabeled objects whose
+// services were deleted before upgrading are left to be deleted by hand.
+func (c *Controller) collectGarbage() {
+	orphans, err := c.findOrphans()
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+
+	reportOnly := c.cfg.Get().GCReportOnly
+	metrics.OrphanedObjects.Reset()
+	for _, o := range orphans {
+		// Checks the services again, since a service may use the public IP after the orphans were found
+		used, err := c.isUsedAddress(o.namespace, o.address)
+		if err != nil {
+			utilruntime.HandleError(err)
+			continue
+		}
+
+		if used {
+			continue
+		}
+
+		metrics.OrphanedObjects.WithLabelValues(o.namespace, o.kind).Inc()
+		if reportOnly {
+			glog.Warningf("Service controller found orphaned %s '%s/%s'", o.kind, o.namespace, o.name)
+			continue
+		}
+
+		if c.dryRun(plan.NewDelete(o.kind, o.namespace, o.name)) {
+			continue
+		}
+
+		glog.Infof("Service controller deleting orphaned %s '%s/%s'", o.kind, o.namespace, o.name)
+		if err := o.delete(); err != nil && !errors.IsNotFound(err) {
+			utilruntime.HandleError(err)
+			continue
+		}
+		metrics.CollectedObjects.WithLabelValues(o.kind).Inc()
+	}
+}
+
+// findOrphans lists the managed objects before the services, so that the object which is created for a
+// new service is never found without the service.
+func (c *Controller) findOrphans() ([]*orphan, error) {
+	opts := metav1.ListOptions{LabelSelector: ManagedSelector().String()}
+	nats, err := c.blendedset.InwinstackV1().NATs(metav1.NamespaceAll).List(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	secs, err := c.blendedset.InwinstackV1().Securities(metav1.NamespaceAll).List(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	objs, err := c.blendedset.InwinstackV1().Services().List(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	used, err := c.usedAddresses()
+	if err != nil {
+		return nil, err
+	}
+
+	isOrphan := func(namespace string, meta metav1.ObjectMeta) bool {
+		if c.cfg.Get().IgnoresNamespace(namespace) {
+			return false
+		}
+		return !funk.ContainsString(used[namespace], addressOf(meta))
+	}
+
+	orphans := []*orphan{}
+	for _, nat := range nats.Items {
+		if isOrphan(nat.Namespace, nat.ObjectMeta) {
+			namespace, name := nat.Namespace, nat.Name
+			orphans = append(orphans, &orphan{kind: plan.KindNAT, namespace: namespace, name: name, address: addressOf(nat.ObjectMeta), delete: func() error {
+				return c.blendedset.InwinstackV1().NATs(namespace).Delete(name, nil)
+			}})
+		}
+	}
+
+	for _, sec := range secs.Items {
+		if isOrphan(sec.Namespace, sec.ObjectMeta) {
+			namespace, name := sec.Namespace, sec.Name
+			orphans = append(orphans, &orphan{kind: plan.KindSecurity, namespace: namespace, name: name, address: addressOf(sec.ObjectMeta), delete: func() error {
+				return c.blendedset.InwinstackV1().Securities(namespace).Delete(name, nil)
+			}})
+		}
+	}
+
+	// The service objects are cluster-scoped, so they are orphaned if no service of any namespace uses the public IP
+	for _, obj := range objs.Items {
+		if isOrphan(metav1.NamespaceAll, obj.ObjectMeta) {
+			name := obj.Name
+			orphans = append(orphans, &orphan{kind: plan.KindService, name: name, address: addressOf(obj.ObjectMeta), delete: func() error {
+				return c.blendedset.InwinstackV1().Services().Delete(name, nil)
+			}})
+		}
+	}
+	return orphans, nil
+}
+
+// usedAddresses returns the public IPs which are used by services per namespace, including the ones being
+// released, and the ones of all namespaces by the empty namespace.
+func (c *Controller) usedAddresses() (map[string][]string, error) {
+	used := map[string][]string{}
+	svcs, err := c.lister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, svc := range svcs {
+		addresses := usedAddressesOf(svc)
+		used[svc.Namespace] = append(used[svc.Namespace], addresses...)
+		used[metav1.NamespaceAll] = append(used[metav1.NamespaceAll], addresses...)
+	}
+	return used, nil
+}
+
+// isUsedAddress checks whether the public IP is used by any service of the namespace, or of all namespaces
+// if the namespace is empty.
+func (c *Controller) isUsedAddress(namespace, addr string) (bool, error) {
+	svcs, err := c.lister.Services(namespace).List(labels.Everything())
+	if err != nil {
+		return false, err
+	}
+
+	for _, svc := range svcs {
+		if funk.ContainsString(usedAddressesOf(svc), addr) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func usedAddressesOf(svc *v1.Service) []string {
+	addresses, _ := ParsePublicIPs(svc.Annotations[constants.PublicIPKey])
+	synced, _ := ParsePublicIPs(svc.Annotations[constants.SyncedPublicIPKey])
+	return append(addresses, synced...)
+}
+
+// addressOf returns the public IP of the managed object from the label, or from the name for the objects
+// which were created before labeling
+func addressOf(meta metav1.ObjectMeta) string {
+	if addr, ok := meta.Labels[constants.PublicIPLabelKey]; ok {
+		return decodeAddress(addr)
+	}
+
+	addr := strings.TrimPrefix(meta.Name, constants.PolicyPrefix+"-")
+	for _, protocol := range objectProtocols {
+		addr = strings.TrimSuffix(addr, "-"+strings.ToLower(string(protocol)))
+	}
+	return decodeAddress(addr)
+}
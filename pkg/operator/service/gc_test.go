@@ -0,0 +1,151 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"testing"
+
+	blendedv1 "github.com/inwinstack/blended/apis/inwinstack/v1"
+	blendedfake "github.com/inwinstack/blended/generated/clientset/versioned/fake"
+	blendedinformers "github.com/inwinstack/blended/generated/informers/externalversions"
+	"github.com/inwinstack/pa-svc-syncker/pkg/config"
+	"github.com/inwinstack/pa-svc-syncker/pkg/constants"
+	"github.com/inwinstack/pa-svc-syncker/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestCollectGarbage(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test-svc",
+			Namespace:   "test",
+			Annotations: map[string]string{constants.PublicIPKey: "140.145.20.10"},
+		},
+	}
+
+	managed := func(addr string) metav1.ObjectMeta {
+		return metav1.ObjectMeta{
+			Name:      "k8s-" + addr,
+			Namespace: "test",
+			Labels:    newLabels(addr, nil),
+		}
+	}
+
+	// The unmanaged Security is never collected
+	unmanaged := metav1.ObjectMeta{Name: "k8s-140.145.20.12", Namespace: "test"}
+	clientset := fake.NewSimpleClientset(svc)
+	blendedset := blendedfake.NewSimpleClientset(
+		&blendedv1.NAT{ObjectMeta: managed("140.145.20.10")},
+		&blendedv1.NAT{ObjectMeta: managed("140.145.20.11")},
+		&blendedv1.Security{ObjectMeta: managed("140.145.20.10")},
+		&blendedv1.Security{ObjectMeta: managed("140.145.20.11")},
+		&blendedv1.Security{ObjectMeta: unmanaged},
+		&blendedv1.Service{ObjectMeta: metav1.ObjectMeta{Name: "k8s-140.145.20.10-tcp", Labels: newLabels("140.145.20.10", nil)}},
+		&blendedv1.Service{ObjectMeta: metav1.ObjectMeta{Name: "k8s-140.145.20.11-tcp", Labels: newLabels("140.145.20.11", nil)}},
+	)
+
+	cfg := &config.Config{Threads: 2, GCReportOnly: true}
+	informer := informers.NewSharedInformerFactory(clientset, 0)
+	blendedInformer := blendedinformers.NewSharedInformerFactory(blendedset, 0)
+	controller := NewController(cfg, clientset, blendedset, informer.Core().V1().Services(), informer.Core().V1().Namespaces(), blendedInformer.Inwinstack().V1().IPs(), blendedInformer.Inwinstack().V1().NATs(), blendedInformer.Inwinstack().V1().Securities(), nil, nil, nil)
+	go informer.Start(ctx.Done())
+	go blendedInformer.Start(ctx.Done())
+	assert.True(t, cache.WaitForCacheSync(ctx.Done(), controller.synced))
+
+	// The orphans are only reported in report-only mode
+	controller.collectGarbage()
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.OrphanedObjects.WithLabelValues("test", "NAT")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.OrphanedObjects.WithLabelValues("test", "Security")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.OrphanedObjects.WithLabelValues("", "Service")))
+
+	nats, err := blendedset.InwinstackV1().NATs("test").List(metav1.ListOptions{})
+	assert.Nil(t, err)
+	assert.Len(t, nats.Items, 2)
+
+	cfg.GCReportOnly = false
+	controller.collectGarbage()
+
+	_, err = blendedset.InwinstackV1().NATs("test").Get("k8s-140.145.20.10", metav1.GetOptions{})
+	assert.Nil(t, err)
+	_, err = blendedset.InwinstackV1().NATs("test").Get("k8s-140.145.20.11", metav1.GetOptions{})
+	assert.NotNil(t, err)
+
+	secs, err := blendedset.InwinstackV1().Securities("test").List(metav1.ListOptions{})
+	assert.Nil(t, err)
+	names := []string{}
+	for _, sec := range secs.Items {
+		names = append(names, sec.Name)
+	}
+	assert.ElementsMatch(t, []string{"k8s-140.145.20.10", "k8s-140.145.20.12"}, names)
+
+	objs, err := blendedset.InwinstackV1().Services().List(metav1.ListOptions{})
+	assert.Nil(t, err)
+	assert.Len(t, objs.Items, 1)
+	assert.Equal(t, "k8s-140.145.20.10-tcp", objs.Items[0].Name)
+
+	// The orphan is checked again before deleting, since a new service may use the public IP after finding it
+	orphans, err := controller.findOrphans()
+	assert.Nil(t, err)
+	assert.Empty(t, orphans)
+
+	_, err = blendedset.InwinstackV1().NATs("test").Create(&blendedv1.NAT{ObjectMeta: managed("140.145.20.13")})
+	assert.Nil(t, err)
+	orphans, err = controller.findOrphans()
+	assert.Nil(t, err)
+	assert.Len(t, orphans, 1)
+	assert.Equal(t, "140.145.20.13", orphans[0].address)
+
+	newSvc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "new-svc",
+			Namespace:   "test",
+			Annotations: map[string]string{constants.PublicIPKey: "140.145.20.13"},
+		},
+	}
+	assert.Nil(t, informer.Core().V1().Services().Informer().GetIndexer().Add(newSvc))
+	used, err := controller.isUsedAddress(orphans[0].namespace, orphans[0].address)
+	assert.Nil(t, err)
+	assert.True(t, used)
+
+	controller.collectGarbage()
+	_, err = blendedset.InwinstackV1().NATs("test").Get("k8s-140.145.20.13", metav1.GetOptions{})
+	assert.Nil(t, err)
+}
+
+func TestAddressOf(t *testing.T) {
+	assert.Equal(t, "140.145.20.10", addressOf(metav1.ObjectMeta{Name: "k8s-140.145.20.10"}))
+	assert.Equal(t, "140.145.20.10", addressOf(metav1.ObjectMeta{Name: "k8s-140.145.20.10-udp"}))
+	assert.Equal(t, "140.145.20.11", addressOf(metav1.ObjectMeta{
+		Name:   "k8s-140.145.20.10",
+		Labels: map[string]string{constants.PublicIPLabelKey: "140.145.20.11"},
+	}))
+	assert.Equal(t, "2001:db8::1", addressOf(metav1.ObjectMeta{Name: "k8s-2001-0db8-0000-0000-0000-0000-0000-0001-tcp"}))
+	assert.Equal(t, "2001:db8::1", addressOf(metav1.ObjectMeta{
+		Name:   "k8s-2001-0db8-0000-0000-0000-0000-0000-0001",
+		Labels: map[string]string{constants.PublicIPLabelKey: "2001-0db8-0000-0000-0000-0000-0000-0001"},
+	}))
+}
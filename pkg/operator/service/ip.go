@@ -0,0 +1,114 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"net"
+
+	blendedv1 "github.com/inwinstack/blended/apis/inwinstack/v1"
+	"github.com/inwinstack/pa-svc-syncker/pkg/constants"
+	"github.com/thoas/go-funk"
+	"k8s.io/apimachinery/pkg/labels"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+)
+
+// The index of IPs by the namespace and the allocated address
+const ipAddressIndex = "address"
+
+// ipAddressKey returns the index key of IP, the address is normalized since the IPv6 address can be
+// written in different forms
+func ipAddressKey(namespace, addr string) string {
+	if ip := net.ParseIP(addr); ip != nil {
+		addr = ip.String()
+	}
+	return namespace + "/" + addr
+}
+
+func ipAddressIndexFunc(obj interface{}) ([]string, error) {
+	ip, ok := obj.(*blendedv1.IP)
+	if !ok || ip.Status.Address == "" {
+		return nil, nil
+	}
+	return []string{ipAddressKey(ip.Namespace, ip.Status.Address)}, nil
+}
+
+// isActiveIP checks whether the public IP has been allocated by an active IP of namespace,
+// the IP is the source of truth instead of the public IP annotation of service.
+func (c *Controller) isActiveIP(namespace, addr string) (bool, error) {
+	objs, err := c.ipIndexer.ByIndex(ipAddressIndex, ipAddressKey(namespace, addr))
+	if err != nil {
+		return false, err
+	}
+
+	for _, obj := range objs {
+		if ip, ok := obj.(*blendedv1.IP); ok && ip.Status.Phase == blendedv1.IPActive {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// enqueueIP enqueues the services which own the IP when its phase or address changed. The services
+// own the IP if the name of IP is one of their external IPs, or the address of IP is one of their
+// public IPs.
+func (c *Controller) enqueueIP(old, new interface{}) {
+	ips := []*blendedv1.IP{}
+	for _, obj := range []interface{}{old, new} {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+
+		if ip, ok := obj.(*blendedv1.IP); ok {
+			ips = append(ips, ip)
+		}
+	}
+
+	if len(ips) == 0 {
+		return
+	}
+
+	if len(ips) == 2 && ips[0].Status.Phase == ips[1].Status.Phase && ips[0].Status.Address == ips[1].Status.Address {
+		return
+	}
+
+	svcs, err := c.lister.Services(ips[0].Namespace).List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+
+	keys := map[string]bool{}
+	names := []string{}
+	for _, ip := range ips {
+		keys[ipAddressKey(ip.Namespace, ip.Status.Address)] = true
+		names = append(names, ip.Name)
+	}
+
+	for _, svc := range svcs {
+		addresses, _ := ParsePublicIPs(svc.Annotations[constants.PublicIPKey])
+		synced, _ := ParsePublicIPs(svc.Annotations[constants.SyncedPublicIPKey])
+		owned := len(funk.IntersectString(svc.Spec.ExternalIPs, names)) > 0
+		for _, addr := range append(addresses, synced...) {
+			owned = owned || keys[ipAddressKey(svc.Namespace, addr)]
+		}
+
+		if owned {
+			c.enqueue(svc)
+		}
+	}
+}
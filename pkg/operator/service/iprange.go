@@ -0,0 +1,174 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"sort"
+	"strings"
+)
+
+var one = big.NewInt(1)
+
+// ipRange is the inclusive range of the addresses of a family, the bits is 32 for IPv4 and 128 for IPv6
+type ipRange struct {
+	first, last *big.Int
+	bits        int
+}
+
+// parseIPRange parses the IP, CIDR or "first-last" range address
+func parseIPRange(addr string) (*ipRange, error) {
+	if parts := strings.SplitN(addr, "-", 2); len(parts) == 2 {
+		first, last := net.ParseIP(parts[0]), net.ParseIP(parts[1])
+		if first == nil || last == nil || isIPv6(parts[0]) != isIPv6(parts[1]) {
+			return nil, fmt.Errorf("invalid IP range '%s'", addr)
+		}
+
+		r := &ipRange{first: ipToInt(first), last: ipToInt(last), bits: ipBits(first)}
+		if r.first.Cmp(r.last) > 0 {
+			return nil, fmt.Errorf("invalid IP range '%s': the first address is greater than the last", addr)
+		}
+		return r, nil
+	}
+
+	if ip := net.ParseIP(addr); ip != nil {
+		first := ipToInt(ip)
+		return &ipRange{first: first, last: new(big.Int).Set(first), bits: ipBits(ip)}, nil
+	}
+
+	_, ipnet, err := net.ParseCIDR(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ones, bits := ipnet.Mask.Size()
+	first := ipToInt(ipnet.IP)
+	last := new(big.Int).Lsh(one, uint(bits-ones))
+	last.Add(last, first).Sub(last, one)
+	return &ipRange{first: first, last: last, bits: bits}, nil
+}
+
+// mergeRanges sorts the ranges by family and address, and merges the overlapping and adjacent ones
+func mergeRanges(ranges []*ipRange) []*ipRange {
+	sorted := append([]*ipRange{}, ranges...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].bits != sorted[j].bits {
+			return sorted[i].bits < sorted[j].bits
+		}
+		return sorted[i].first.Cmp(sorted[j].first) < 0
+	})
+
+	merged := []*ipRange{}
+	for _, r := range sorted {
+		if n := len(merged); n > 0 && merged[n-1].bits == r.bits {
+			prev := merged[n-1]
+			next := new(big.Int).Add(prev.last, one)
+			if r.first.Cmp(next) <= 0 {
+				if r.last.Cmp(prev.last) > 0 {
+					prev.last = r.last
+				}
+				continue
+			}
+		}
+		merged = append(merged, &ipRange{first: r.first, last: r.last, bits: r.bits})
+	}
+	return merged
+}
+
+// subtractRanges removes the excluded ranges from the ranges
+func subtractRanges(ranges, excluded []*ipRange) []*ipRange {
+	result := []*ipRange{}
+	for _, r := range ranges {
+		pieces := []*ipRange{r}
+		for _, e := range excluded {
+			rest := []*ipRange{}
+			for _, p := range pieces {
+				rest = append(rest, p.subtract(e)...)
+			}
+			pieces = rest
+		}
+		result = append(result, pieces...)
+	}
+	return result
+}
+
+// subtract returns the pieces of the range which aren't covered by the other range
+func (r *ipRange) subtract(other *ipRange) []*ipRange {
+	if r.bits != other.bits || other.last.Cmp(r.first) < 0 || other.first.Cmp(r.last) > 0 {
+		return []*ipRange{r}
+	}
+
+	pieces := []*ipRange{}
+	if r.first.Cmp(other.first) < 0 {
+		pieces = append(pieces, &ipRange{first: r.first, last: new(big.Int).Sub(other.first, one), bits: r.bits})
+	}
+	if r.last.Cmp(other.last) > 0 {
+		pieces = append(pieces, &ipRange{first: new(big.Int).Add(other.last, one), last: r.last, bits: r.bits})
+	}
+	return pieces
+}
+
+// cidrs splits the range into the minimal CIDR addresses, the host network is written as an IP
+func (r *ipRange) cidrs() []string {
+	addresses := []string{}
+	first := new(big.Int).Set(r.first)
+	for first.Cmp(r.last) <= 0 {
+		// The largest block which is aligned to the first address and doesn't exceed the last address
+		size := 0
+		for size < r.bits && first.Bit(size) == 0 {
+			size++
+		}
+
+		count := new(big.Int).Sub(r.last, first)
+		count.Add(count, one)
+		for size > 0 && new(big.Int).Lsh(one, uint(size)).Cmp(count) > 0 {
+			size--
+		}
+
+		ip := intToIP(first, r.bits)
+		if size == 0 {
+			addresses = append(addresses, ip.String())
+		} else {
+			addresses = append(addresses, fmt.Sprintf("%s/%d", ip, r.bits-size))
+		}
+		first.Add(first, new(big.Int).Lsh(one, uint(size)))
+	}
+	return addresses
+}
+
+func ipBits(ip net.IP) int {
+	if ip.To4() != nil {
+		return 32
+	}
+	return 128
+}
+
+func ipToInt(ip net.IP) *big.Int {
+	if ip4 := ip.To4(); ip4 != nil {
+		return new(big.Int).SetBytes(ip4)
+	}
+	return new(big.Int).SetBytes(ip.To16())
+}
+
+func intToIP(i *big.Int, bits int) net.IP {
+	b := i.Bytes()
+	ip := make(net.IP, bits/8)
+	copy(ip[len(ip)-len(b):], b)
+	return ip
+}
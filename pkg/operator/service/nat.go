@@ -20,21 +20,39 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/golang/glog"
 	blendedv1 "github.com/inwinstack/blended/apis/inwinstack/v1"
 	"github.com/inwinstack/pa-svc-syncker/pkg/constants"
+	"github.com/inwinstack/pa-svc-syncker/pkg/metrics"
+	"github.com/inwinstack/pa-svc-syncker/pkg/plan"
+	"github.com/inwinstack/pa-svc-syncker/pkg/syncpolicy"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-func (c *Controller) newNAT(name, addr string, svc *v1.Service) *blendedv1.NAT {
-	return &blendedv1.NAT{
+const natDescription = "Automatically sync NAT for Kubernetes service."
+
+// natType returns the NAT type by the family of public IP. The IPv6 public IP is translated to the
+// external IP by static destination NAT as well, since blended doesn't support NPTv6.
+func natType(addr string) string {
+	if isIPv6(addr) {
+		return blendedv1.NATIPv6
+	}
+	return blendedv1.NATIPv4
+}
+
+func (c *Controller) newNAT(name, addr, externalIP string, sp *syncpolicy.SyncPolicy, owners []*v1.Service, svc *v1.Service) *blendedv1.NAT {
+	nat := &blendedv1.NAT{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      name,
-			Namespace: svc.Namespace,
+			Name:            name,
+			Namespace:       svc.Namespace,
+			Labels:          newLabels(addr, owners),
+			OwnerReferences: newOwnerReferences(owners),
 		},
 		Spec: blendedv1.NATSpec{
-			Type:                 blendedv1.NATIPv4,
-			SourceZones:          c.cfg.SourceZones,
+			Type:                 natType(addr),
+			SourceZones:          c.cfg.Get().SourceZones,
 			SourceAddresses:      []string{"any"},
 			DestinationAddresses: []string{addr},
 			DestinationZone:      "untrust",
@@ -42,32 +60,98 @@ func (c *Controller) newNAT(name, addr string, svc *v1.Service) *blendedv1.NAT {
 			Service:              "any",
 			SatType:              blendedv1.NATSatNone,
 			DatType:              blendedv1.NATDatStatic,
-			DatAddress:           svc.Spec.ExternalIPs[0],
-			Description:          "Automatically sync NAT for Kubernetes service.",
+			DatAddress:           externalIP,
+			Description:          natDescription,
 		},
 	}
+
+	// The fields of SyncPolicy take precedence over config
+	if sp != nil {
+		overrideList(&nat.Spec.SourceZones, sp.Spec.NAT.SourceZones)
+		overrideName(&nat.Spec.DestinationZone, sp.Spec.NAT.DestinationZone)
+	}
+	return nat
 }
 
-func (c *Controller) createNAT(addr string, svc *v1.Service) error {
-	name := fmt.Sprintf("%s-%s", constants.PolicyPrefix, addr)
-	if _, err := c.blendedset.InwinstackV1().NATs(svc.Namespace).Get(name, metav1.GetOptions{}); err == nil {
+func (c *Controller) syncNAT(addr, externalIP string, owners []*v1.Service, svc *v1.Service) error {
+	name := policyName(addr)
+	ns, err := c.nsLister.Get(svc.Namespace)
+	if err != nil {
+		return err
+	}
+
+	// The NAT is shared by the owners, so it follows the SyncPolicy of the first owner which
+	// is also recorded in the labels.
+	var owner *v1.Service
+	if sorted := sortOwners(owners); len(sorted) > 0 {
+		owner = sorted[0]
+	}
+
+	nat := c.newNAT(name, addr, externalIP, c.policies.Match(ns, owner), owners, svc)
+	current, err := c.blendedset.InwinstackV1().NATs(svc.Namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+
+		if c.dryRun(plan.NewCreate(plan.KindNAT, nat.Namespace, name, nat)) {
+			return nil
+		}
+
+		if _, err := c.blendedset.InwinstackV1().NATs(svc.Namespace).Create(nat); err != nil {
+			return err
+		}
+		c.recorder.Eventf(svc, v1.EventTypeNormal, constants.NATCreatedReason, "Created NAT '%s'", name)
+		return nil
+	}
+
+	if !isOwned(current.ObjectMeta, current.Spec.Description, natDescription) {
+		return fmt.Errorf("NAT '%s/%s' is not managed by syncker", svc.Namespace, name)
+	}
+
+	currentCopy := current.DeepCopy()
+	metaDrifted := syncMeta(&currentCopy.ObjectMeta, nat.ObjectMeta)
+	specDrift := syncNATSpec(&currentCopy.Spec, &nat.Spec)
+	drifted := append(metaDrifted, specDrift.fields()...)
+	if len(drifted) == 0 {
+		glog.V(4).Infof("Service controller skipped updating NAT '%s/%s' which is up to date", svc.Namespace, name)
+		metrics.ObjectUpdates.WithLabelValues(plan.KindNAT, metrics.UpdateSkipped).Inc()
+		c.plan.Resolve(plan.KindNAT, svc.Namespace, name)
 		return nil
 	}
 
-	nat := c.newNAT(name, addr, svc)
-	if _, err := c.blendedset.InwinstackV1().NATs(svc.Namespace).Create(nat); err != nil {
+	diff := append(plan.Diff(&current.ObjectMeta, &currentCopy.ObjectMeta, metaDrifted), specDrift.diffs...)
+	if c.dryRun(plan.NewUpdate(plan.KindNAT, svc.Namespace, name, diff)) {
+		return nil
+	}
+
+	glog.Infof("Service controller detected drift on NAT '%s/%s': %s", svc.Namespace, name, strings.Join(drifted, ", "))
+	if _, err := c.blendedset.InwinstackV1().NATs(svc.Namespace).Update(currentCopy); err != nil {
 		return err
 	}
+	metrics.ObjectUpdates.WithLabelValues(plan.KindNAT, metrics.UpdateWritten).Inc()
+	c.recorder.Eventf(svc, v1.EventTypeNormal, constants.NATUpdatedReason, "Updated drifted NAT '%s': %s", name, strings.Join(drifted, ", "))
 	return nil
 }
 
 func (c *Controller) deleteNAT(addr string, svc *v1.Service) error {
-	name := fmt.Sprintf("%s-%s", constants.PolicyPrefix, addr)
-	if _, err := c.blendedset.InwinstackV1().NATs(svc.Namespace).Get(name, metav1.GetOptions{}); err != nil {
-		if strings.Contains(err.Error(), "not found") {
+	name := policyName(addr)
+	nat, err := c.blendedset.InwinstackV1().NATs(svc.Namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			c.plan.Resolve(plan.KindNAT, svc.Namespace, name)
 			return nil
 		}
 		return err
 	}
+
+	if !isOwned(nat.ObjectMeta, nat.Spec.Description, natDescription) {
+		glog.Warningf("Service controller skipped deleting NAT '%s/%s' which is not managed by syncker", svc.Namespace, name)
+		return nil
+	}
+
+	if c.dryRun(plan.NewDelete(plan.KindNAT, svc.Namespace, name)) {
+		return nil
+	}
 	return c.blendedset.InwinstackV1().NATs(svc.Namespace).Delete(name, nil)
 }
@@ -0,0 +1,214 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+	blendedv1 "github.com/inwinstack/blended/apis/inwinstack/v1"
+	"github.com/inwinstack/pa-svc-syncker/pkg/metrics"
+	"github.com/inwinstack/pa-svc-syncker/pkg/plan"
+	"github.com/thoas/go-funk"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// The protocols that can be expressed by a PA service object
+var objectProtocols = []v1.Protocol{v1.ProtocolTCP, v1.ProtocolUDP}
+
+func objectName(addr string, protocol v1.Protocol) string {
+	return fmt.Sprintf("%s-%s", policyName(addr), strings.ToLower(string(protocol)))
+}
+
+// formatPorts collapses the ports into the PA destination port syntax, e.g. "80,443,8000-8002"
+func formatPorts(ports []int32) string {
+	if len(ports) == 0 {
+		return ""
+	}
+
+	sorted := make([]int, 0, len(ports))
+	seen := map[int32]bool{}
+	for _, port := range ports {
+		if !seen[port] {
+			seen[port] = true
+			sorted = append(sorted, int(port))
+		}
+	}
+	sort.Ints(sorted)
+
+	ranges := []string{}
+	start, end := sorted[0], sorted[0]
+	for _, port := range append(sorted[1:], -1) {
+		if port == end+1 {
+			end = port
+			continue
+		}
+
+		if start == end {
+			ranges = append(ranges, fmt.Sprintf("%d", start))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", start, end))
+		}
+		start, end = port, port
+	}
+	return strings.Join(ranges, ",")
+}
+
+// groupPorts groups the ports of services by protocol
+func groupPorts(svcs []*v1.Service) map[v1.Protocol][]int32 {
+	ports := map[v1.Protocol][]int32{}
+	for _, svc := range svcs {
+		for _, port := range svc.Spec.Ports {
+			protocol := port.Protocol
+			if protocol == "" {
+				protocol = v1.ProtocolTCP
+			}
+			ports[protocol] = append(ports[protocol], port.Port)
+		}
+	}
+	return ports
+}
+
+// unsupportedProtocols returns the protocols of ports which have no service object, e.g. SCTP
+func unsupportedProtocols(svcs []*v1.Service) []string {
+	protocols := []string{}
+	for protocol := range groupPorts(svcs) {
+		if !funk.Contains(objectProtocols, protocol) {
+			protocols = append(protocols, string(protocol))
+		}
+	}
+	sort.Strings(protocols)
+	return protocols
+}
+
+const objectDescription = "Automatically sync Service for Kubernetes service."
+
+func (c *Controller) newServiceObject(name, addr string, protocol v1.Protocol, ports []int32, owners []*v1.Service) *blendedv1.Service {
+	return &blendedv1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: newLabels(addr, owners),
+		},
+		Spec: blendedv1.ServiceSpec{
+			Protocol:        strings.ToLower(string(protocol)),
+			DestinationPort: formatPorts(ports),
+			Description:     objectDescription,
+		},
+	}
+}
+
+// syncServiceObjects creates, updates or deletes the service objects of the public IP, and
+// returns the names of service objects which should be referenced by the Security.
+func (c *Controller) syncServiceObjects(addr string, svcs []*v1.Service) ([]string, error) {
+	names := []string{}
+	ports := groupPorts(svcs)
+	for _, protocol := range objectProtocols {
+		name := objectName(addr, protocol)
+		if len(ports[protocol]) == 0 {
+			if err := c.deleteServiceObject(name); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		obj := c.newServiceObject(name, addr, protocol, ports[protocol], svcs)
+		if err := c.createOrUpdateServiceObject(obj); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func (c *Controller) createOrUpdateServiceObject(obj *blendedv1.Service) error {
+	current, err := c.blendedset.InwinstackV1().Services().Get(obj.Name, metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+
+		if c.dryRun(plan.NewCreate(plan.KindService, "", obj.Name, obj)) {
+			return nil
+		}
+
+		if _, err := c.blendedset.InwinstackV1().Services().Create(obj); err != nil {
+			return err
+		}
+		return nil
+	}
+
+	if !isOwned(current.ObjectMeta, current.Spec.Description, objectDescription) {
+		return fmt.Errorf("Service object '%s' is not managed by syncker", obj.Name)
+	}
+
+	currentCopy := current.DeepCopy()
+	metaDrifted := syncMeta(&currentCopy.ObjectMeta, obj.ObjectMeta)
+	specDrift := syncObjectSpec(&currentCopy.Spec, &obj.Spec)
+	drifted := append(metaDrifted, specDrift.fields()...)
+	if len(drifted) == 0 {
+		glog.V(4).Infof("Service controller skipped updating service object '%s' which is up to date", obj.Name)
+		metrics.ObjectUpdates.WithLabelValues(plan.KindService, metrics.UpdateSkipped).Inc()
+		c.plan.Resolve(plan.KindService, "", obj.Name)
+		return nil
+	}
+
+	diff := append(plan.Diff(&current.ObjectMeta, &currentCopy.ObjectMeta, metaDrifted), specDrift.diffs...)
+	if c.dryRun(plan.NewUpdate(plan.KindService, "", obj.Name, diff)) {
+		return nil
+	}
+
+	glog.Infof("Service controller detected drift on Service object '%s': %s", obj.Name, strings.Join(drifted, ", "))
+	if _, err := c.blendedset.InwinstackV1().Services().Update(currentCopy); err != nil {
+		return err
+	}
+	metrics.ObjectUpdates.WithLabelValues(plan.KindService, metrics.UpdateWritten).Inc()
+	return nil
+}
+
+func (c *Controller) deleteServiceObject(name string) error {
+	obj, err := c.blendedset.InwinstackV1().Services().Get(name, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			c.plan.Resolve(plan.KindService, "", name)
+			return nil
+		}
+		return err
+	}
+
+	if !isOwned(obj.ObjectMeta, obj.Spec.Description, objectDescription) {
+		glog.Warningf("Service controller skipped deleting Service object '%s' which is not managed by syncker", name)
+		return nil
+	}
+
+	if c.dryRun(plan.NewDelete(plan.KindService, "", name)) {
+		return nil
+	}
+	return c.blendedset.InwinstackV1().Services().Delete(name, nil)
+}
+
+func (c *Controller) deleteServiceObjects(addr string) error {
+	for _, protocol := range objectProtocols {
+		if err := c.deleteServiceObject(objectName(addr, protocol)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestFormatPorts(t *testing.T) {
+	tests := []struct {
+		Ports    []int32
+		Expected string
+	}{
+		{Ports: nil, Expected: ""},
+		{Ports: []int32{443}, Expected: "443"},
+		{Ports: []int32{443, 80}, Expected: "80,443"},
+		{Ports: []int32{8002, 8000, 8001, 80, 80}, Expected: "80,8000-8002"},
+		{Ports: []int32{1, 2, 4, 5, 7}, Expected: "1-2,4-5,7"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.Expected, formatPorts(test.Ports))
+	}
+}
+
+func TestGroupPorts(t *testing.T) {
+	svcs := []*corev1.Service{
+		{
+			Spec: corev1.ServiceSpec{
+				Ports: []corev1.ServicePort{
+					{Port: 80, Protocol: corev1.ProtocolTCP},
+					{Port: 53, Protocol: corev1.ProtocolUDP},
+				},
+			},
+		},
+		{
+			Spec: corev1.ServiceSpec{
+				Ports: []corev1.ServicePort{
+					{Port: 443},
+				},
+			},
+		},
+	}
+
+	ports := groupPorts(svcs)
+	assert.Equal(t, []int32{80, 443}, ports[corev1.ProtocolTCP])
+	assert.Equal(t, []int32{53}, ports[corev1.ProtocolUDP])
+}
+
+func TestUnsupportedProtocols(t *testing.T) {
+	svcs := []*corev1.Service{
+		{
+			Spec: corev1.ServiceSpec{
+				Ports: []corev1.ServicePort{
+					{Port: 80, Protocol: corev1.ProtocolTCP},
+					{Port: 3868, Protocol: corev1.ProtocolSCTP},
+				},
+			},
+		},
+	}
+	assert.Equal(t, []string{"SCTP"}, unsupportedProtocols(svcs))
+	assert.Equal(t, []string{}, unsupportedProtocols(svcs[:0]))
+}
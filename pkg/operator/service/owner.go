@@ -0,0 +1,145 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"reflect"
+	"sort"
+
+	"github.com/inwinstack/pa-svc-syncker/pkg/constants"
+	"github.com/thoas/go-funk"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// ManagedSelector returns the label selector for the objects which are managed by syncker
+func ManagedSelector() labels.Selector {
+	return labels.SelectorFromSet(labels.Set{constants.ManagedByKey: constants.ManagedByValue})
+}
+
+// sortOwners sorts the owners by name, so that the first owner is stable
+func sortOwners(owners []*v1.Service) []*v1.Service {
+	sorted := make([]*v1.Service, len(owners))
+	copy(sorted, owners)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+	return sorted
+}
+
+// newLabels returns the ownership labels of the public IP, the source service is the first owner
+func newLabels(addr string, owners []*v1.Service) map[string]string {
+	labels := map[string]string{
+		constants.ManagedByKey:     constants.ManagedByValue,
+		constants.PublicIPLabelKey: encodeAddress(addr),
+	}
+
+	if sorted := sortOwners(owners); len(sorted) > 0 {
+		labels[constants.ServiceNamespaceKey] = sorted[0].Namespace
+		labels[constants.ServiceNameKey] = sorted[0].Name
+	}
+	return labels
+}
+
+// ownerKeys returns the keys of services which own the object, the source service label is preferred
+// and the owner references are used if the object has no label.
+func ownerKeys(meta metav1.Object) []string {
+	labels := meta.GetLabels()
+	if name, ok := labels[constants.ServiceNameKey]; ok {
+		namespace := labels[constants.ServiceNamespaceKey]
+		if namespace == "" {
+			namespace = meta.GetNamespace()
+		}
+		return []string{namespace + "/" + name}
+	}
+
+	keys := []string{}
+	for _, ref := range meta.GetOwnerReferences() {
+		if ref.Kind == "Service" {
+			keys = append(keys, meta.GetNamespace()+"/"+ref.Name)
+		}
+	}
+	return keys
+}
+
+// newOwnerReferences returns the owner references of all services which are used the public IP. The object
+// is only deleted by the garbage collector after all of its owners are gone, and each owner is kept by the
+// finalizer until it has released the public IP, so the garbage collector never races the cleanup. None of
+// the references is the controller, since the object is shared, and they don't block the deletion of owners.
+func newOwnerReferences(owners []*v1.Service) []metav1.OwnerReference {
+	refs := []metav1.OwnerReference{}
+	for _, owner := range sortOwners(owners) {
+		controller, blockOwnerDeletion := false, false
+		refs = append(refs, metav1.OwnerReference{
+			APIVersion:         "v1",
+			Kind:               "Service",
+			Name:               owner.Name,
+			UID:                owner.UID,
+			Controller:         &controller,
+			BlockOwnerDeletion: &blockOwnerDeletion,
+		})
+	}
+	return refs
+}
+
+// mergeOwnerReferences returns the desired service references, following the current references of other
+// kinds, which are not set by syncker.
+func mergeOwnerReferences(current, desired []metav1.OwnerReference) []metav1.OwnerReference {
+	refs := []metav1.OwnerReference{}
+	for _, ref := range current {
+		if ref.APIVersion != "v1" || ref.Kind != "Service" {
+			refs = append(refs, ref)
+		}
+	}
+	return append(refs, desired...)
+}
+
+// isOwned checks whether the object is managed by syncker. The objects which were created by
+// the older version have no labels, so they are adopted if the description is generated by syncker.
+func isOwned(meta metav1.ObjectMeta, current, description string) bool {
+	if value, ok := meta.Labels[constants.ManagedByKey]; ok {
+		return value == constants.ManagedByValue
+	}
+	return current == description
+}
+
+// syncMeta copies the ownership labels and owner references from desired to current when they are drifted,
+// and returns the names of drifted fields.
+func syncMeta(current *metav1.ObjectMeta, desired metav1.ObjectMeta) []string {
+	drifted := []string{}
+	for key, value := range desired.Labels {
+		if current.Labels[key] != value {
+			if current.Labels == nil {
+				current.Labels = map[string]string{}
+			}
+			current.Labels[key] = value
+			if !funk.ContainsString(drifted, "Labels") {
+				drifted = append(drifted, "Labels")
+			}
+		}
+	}
+
+	if desired.OwnerReferences != nil {
+		refs := mergeOwnerReferences(current.OwnerReferences, desired.OwnerReferences)
+		if !reflect.DeepEqual(current.OwnerReferences, refs) {
+			current.OwnerReferences = refs
+			drifted = append(drifted, "OwnerReferences")
+		}
+	}
+	return drifted
+}
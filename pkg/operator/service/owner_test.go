@@ -0,0 +1,125 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"testing"
+
+	"github.com/inwinstack/pa-svc-syncker/pkg/constants"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestIsOwned(t *testing.T) {
+	tests := []struct {
+		Meta        metav1.ObjectMeta
+		Description string
+		Expected    bool
+	}{
+		{
+			Meta:        metav1.ObjectMeta{Labels: map[string]string{constants.ManagedByKey: constants.ManagedByValue}},
+			Description: "",
+			Expected:    true,
+		},
+		{
+			Meta:        metav1.ObjectMeta{Labels: map[string]string{constants.ManagedByKey: "someone"}},
+			Description: securityDescription,
+			Expected:    false,
+		},
+		{
+			Meta:        metav1.ObjectMeta{},
+			Description: securityDescription,
+			Expected:    true,
+		},
+		{
+			Meta:        metav1.ObjectMeta{},
+			Description: "Hand-written Security.",
+			Expected:    false,
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.Expected, isOwned(test.Meta, test.Description, securityDescription))
+	}
+}
+
+func TestSyncMeta(t *testing.T) {
+	owners := []*corev1.Service{
+		{ObjectMeta: metav1.ObjectMeta{Name: "svc-b", Namespace: "test", UID: "b"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "svc-a", Namespace: "test", UID: "a"}},
+	}
+	desired := metav1.ObjectMeta{
+		Labels:          newLabels("140.11.22.33", owners),
+		OwnerReferences: newOwnerReferences(owners),
+	}
+	assert.Equal(t, "svc-a", desired.Labels[constants.ServiceNameKey])
+	assert.Equal(t, "test", desired.Labels[constants.ServiceNamespaceKey])
+	assert.Equal(t, "140.11.22.33", desired.Labels[constants.PublicIPLabelKey])
+	assert.Equal(t, 2, len(desired.OwnerReferences))
+	for _, ref := range desired.OwnerReferences {
+		assert.False(t, *ref.Controller)
+		assert.False(t, *ref.BlockOwnerDeletion)
+	}
+
+	// The owner references of other kinds are kept
+	other := metav1.OwnerReference{APIVersion: "v1", Kind: "ConfigMap", Name: "config", UID: "c"}
+	current := metav1.ObjectMeta{
+		Labels:          map[string]string{"app": "test"},
+		OwnerReferences: []metav1.OwnerReference{other},
+	}
+	assert.Equal(t, []string{"Labels", "OwnerReferences"}, syncMeta(&current, desired))
+	assert.Equal(t, "test", current.Labels["app"])
+	assert.Equal(t, constants.ManagedByValue, current.Labels[constants.ManagedByKey])
+	assert.Equal(t, append([]metav1.OwnerReference{other}, desired.OwnerReferences...), current.OwnerReferences)
+	assert.Equal(t, []string{}, syncMeta(&current, desired))
+
+	// The reference of released service is removed
+	desired.OwnerReferences = newOwnerReferences(owners[:1])
+	assert.Equal(t, []string{"OwnerReferences"}, syncMeta(&current, desired))
+	assert.Len(t, current.OwnerReferences, 2)
+	assert.Equal(t, "svc-b", current.OwnerReferences[1].Name)
+}
+
+func TestOwnerKeys(t *testing.T) {
+	owners := []*corev1.Service{
+		{ObjectMeta: metav1.ObjectMeta{Name: "svc-b", Namespace: "test"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "svc-a", Namespace: "test"}},
+	}
+
+	tests := []struct {
+		Meta     metav1.ObjectMeta
+		Expected []string
+	}{
+		{
+			Meta:     metav1.ObjectMeta{Namespace: "test", Labels: newLabels("140.11.22.33", owners)},
+			Expected: []string{"test/svc-a"},
+		},
+		{
+			Meta:     metav1.ObjectMeta{Namespace: "test", OwnerReferences: newOwnerReferences(owners)},
+			Expected: []string{"test/svc-a", "test/svc-b"},
+		},
+		{
+			Meta:     metav1.ObjectMeta{Namespace: "test"},
+			Expected: []string{},
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.Expected, ownerKeys(&test.Meta))
+	}
+}
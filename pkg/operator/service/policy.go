@@ -0,0 +1,417 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	blendedv1 "github.com/inwinstack/blended/apis/inwinstack/v1"
+	"github.com/inwinstack/pa-svc-syncker/pkg/config"
+	"github.com/inwinstack/pa-svc-syncker/pkg/constants"
+	"github.com/inwinstack/pa-svc-syncker/pkg/syncpolicy"
+	"github.com/thoas/go-funk"
+	v1 "k8s.io/api/core/v1"
+)
+
+// SecurityPolicy contains the Security fields which can be overridden by annotations
+type SecurityPolicy struct {
+	SourceZones      []string
+	SourceAddresses  []string
+	DestinationZones []string
+	SourceUsers      []string
+	HipProfiles      []string
+	Applications     []string
+	Categories       []string
+	Action           string
+	LogSetting       string
+	Group            string
+}
+
+// NewSecurityPolicy returns the default security policy from config, the fields of SyncPolicy take
+// precedence over config if the SyncPolicy is given
+func NewSecurityPolicy(cfg *config.Config, sp *syncpolicy.SyncPolicy) *SecurityPolicy {
+	policy := &SecurityPolicy{
+		SourceZones:      cfg.SourceZones,
+		SourceAddresses:  []string{"any"},
+		DestinationZones: cfg.DestinationZones,
+		SourceUsers:      cfg.SourceUsers,
+		HipProfiles:      cfg.HipProfiles,
+		Applications:     cfg.Applications,
+		Categories:       cfg.Categories,
+		Action:           blendedv1.SecurityAllow,
+		LogSetting:       cfg.LogSettingName,
+		Group:            cfg.GroupName,
+	}
+
+	if sp != nil {
+		spec := sp.Spec.Security
+		overrideList(&policy.SourceZones, spec.SourceZones)
+		overrideList(&policy.DestinationZones, spec.DestinationZones)
+		overrideList(&policy.SourceUsers, spec.SourceUsers)
+		overrideList(&policy.HipProfiles, spec.HipProfiles)
+		overrideList(&policy.Applications, spec.Applications)
+		overrideList(&policy.Categories, spec.Categories)
+		overrideName(&policy.LogSetting, spec.LogSetting)
+		overrideName(&policy.Group, spec.Group)
+	}
+	return policy
+}
+
+// NamespaceSecurityPolicy returns the copy of policy which is overridden by the namespace annotations,
+// the whitelist is parsed by the cache if it isn't nil
+func NamespaceSecurityPolicy(policy *SecurityPolicy, whitelists *WhitelistCache, ns *v1.Namespace) (*SecurityPolicy, error) {
+	sourceAddresses, err := whitelists.Parse(ns)
+	if err != nil {
+		return nil, err
+	}
+
+	p := policy.copy()
+	p.SourceAddresses = sourceAddresses
+	if err := p.Override(ns.Annotations); err != nil {
+		return nil, fmt.Errorf("namespace '%s' has %s", ns.Name, err.Error())
+	}
+	return p, nil
+}
+
+// The annotations of namespace which are rendered into the Securities
+var namespaceSecurityKeys = []string{
+	constants.WhiteListAddressesKey,
+	constants.WhitelistSetsKey,
+	constants.SecuritySourceZonesKey,
+	constants.SecurityDestinationZonesKey,
+	constants.SecuritySourceUsersKey,
+	constants.SecurityHipProfilesKey,
+	constants.SecurityApplicationsKey,
+	constants.SecurityCategoriesKey,
+	constants.SecurityLogSettingKey,
+	constants.SecurityGroupKey,
+}
+
+// SecurityAnnotationsChanged checks whether the whitelist or security annotations of namespace are changed
+func SecurityAnnotationsChanged(old, new *v1.Namespace) bool {
+	for _, key := range namespaceSecurityKeys {
+		oldValue, oldOk := old.Annotations[key]
+		newValue, newOk := new.Annotations[key]
+		if oldOk != newOk || oldValue != newValue {
+			return true
+		}
+	}
+	return false
+}
+
+// ServiceSecurityPolicy returns the security policy of the Security which is shared by the owners.
+//
+// The precedence of the fields, from low to high, is:
+//  1. The flags of syncker.
+//  2. The SyncPolicy which matches the Namespace and the Service, see syncpolicy.Store.Match.
+//  3. The annotations of Namespace, see NamespaceSecurityPolicy.
+//  4. The annotations of Service for the applications, categories, action, log setting and
+//     whitelist addresses. The whitelist addresses of Service can only narrow the whitelist
+//     of Namespace, so they are intersected with it.
+//
+// When the public IP is shared by multiple Services, the Security must admit the traffic of
+// all of them, so the lists of each Service are merged and "any" takes over the other items,
+// while the action, log setting and group must be the same across the Services.
+func ServiceSecurityPolicy(cfg *config.Config, policies *syncpolicy.Store, whitelists *WhitelistCache, ns *v1.Namespace, owners []*v1.Service) (*SecurityPolicy, error) {
+	if len(owners) == 0 {
+		return NamespaceSecurityPolicy(NewSecurityPolicy(cfg, policies.Match(ns, nil)), whitelists, ns)
+	}
+
+	var merged *SecurityPolicy
+	for _, owner := range sortOwners(owners) {
+		p, err := NamespaceSecurityPolicy(NewSecurityPolicy(cfg, policies.Match(ns, owner)), whitelists, ns)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := p.overrideService(owner.Annotations); err != nil {
+			return nil, fmt.Errorf("service '%s/%s' has %s", owner.Namespace, owner.Name, err.Error())
+		}
+
+		if merged == nil {
+			merged = p
+			continue
+		}
+
+		if err := merged.merge(p); err != nil {
+			return nil, fmt.Errorf("service '%s/%s' conflicts with the other services of the public IP: %s", owner.Namespace, owner.Name, err.Error())
+		}
+	}
+	return merged, nil
+}
+
+// Override overrides the policy by the annotations, and returns an error if any annotation is invalid
+func (p *SecurityPolicy) Override(annotations map[string]string) error {
+	lists := map[string]*[]string{
+		constants.SecuritySourceZonesKey:      &p.SourceZones,
+		constants.SecurityDestinationZonesKey: &p.DestinationZones,
+		constants.SecuritySourceUsersKey:      &p.SourceUsers,
+		constants.SecurityHipProfilesKey:      &p.HipProfiles,
+		constants.SecurityApplicationsKey:     &p.Applications,
+		constants.SecurityCategoriesKey:       &p.Categories,
+	}
+	if err := overrideLists(annotations, lists); err != nil {
+		return err
+	}
+
+	names := map[string]*string{
+		constants.SecurityLogSettingKey: &p.LogSetting,
+		constants.SecurityGroupKey:      &p.Group,
+	}
+	return overrideNames(annotations, names)
+}
+
+// overrideService overrides the policy by the annotations of Service
+func (p *SecurityPolicy) overrideService(annotations map[string]string) error {
+	lists := map[string]*[]string{
+		constants.SecurityApplicationsKey: &p.Applications,
+		constants.SecurityCategoriesKey:   &p.Categories,
+	}
+	if err := overrideLists(annotations, lists); err != nil {
+		return err
+	}
+
+	names := map[string]*string{
+		constants.SecurityActionKey:     &p.Action,
+		constants.SecurityLogSettingKey: &p.LogSetting,
+	}
+	if err := overrideNames(annotations, names); err != nil {
+		return err
+	}
+
+	if p.Action != blendedv1.SecurityAllow && p.Action != blendedv1.SecurityDeny {
+		return fmt.Errorf("invalid annotation '%s': unknown action '%s'", constants.SecurityActionKey, p.Action)
+	}
+
+	if value, ok := annotations[constants.WhiteListAddressesKey]; ok {
+		addresses, err := parseWhitelist(value)
+		if err != nil {
+			return fmt.Errorf("invalid annotation '%s': %s", constants.WhiteListAddressesKey, err.Error())
+		}
+
+		sourceAddresses := intersectAddresses(p.SourceAddresses, addresses)
+		if len(sourceAddresses) == 0 {
+			return fmt.Errorf("invalid annotation '%s': no address is allowed by the namespace whitelist", constants.WhiteListAddressesKey)
+		}
+		p.SourceAddresses = sourceAddresses
+	}
+	return nil
+}
+
+// merge merges the policy of the other Service which shares the same Security
+func (p *SecurityPolicy) merge(other *SecurityPolicy) error {
+	if p.Action != other.Action {
+		return fmt.Errorf("action '%s' and '%s' are conflicting", p.Action, other.Action)
+	}
+
+	if p.LogSetting != other.LogSetting {
+		return fmt.Errorf("log setting '%s' and '%s' are conflicting", p.LogSetting, other.LogSetting)
+	}
+
+	if p.Group != other.Group {
+		return fmt.Errorf("group '%s' and '%s' are conflicting", p.Group, other.Group)
+	}
+
+	p.SourceZones = mergeList(p.SourceZones, other.SourceZones)
+	p.DestinationZones = mergeList(p.DestinationZones, other.DestinationZones)
+	p.SourceUsers = mergeList(p.SourceUsers, other.SourceUsers)
+	p.HipProfiles = mergeList(p.HipProfiles, other.HipProfiles)
+	p.SourceAddresses = mergeList(p.SourceAddresses, other.SourceAddresses)
+	p.Applications = mergeList(p.Applications, other.Applications)
+	p.Categories = mergeList(p.Categories, other.Categories)
+	return nil
+}
+
+func (p *SecurityPolicy) copy() *SecurityPolicy {
+	out := *p
+	out.SourceZones = copyList(p.SourceZones)
+	out.SourceAddresses = copyList(p.SourceAddresses)
+	out.DestinationZones = copyList(p.DestinationZones)
+	out.SourceUsers = copyList(p.SourceUsers)
+	out.HipProfiles = copyList(p.HipProfiles)
+	out.Applications = copyList(p.Applications)
+	out.Categories = copyList(p.Categories)
+	return &out
+}
+
+// Apply sets the policy fields into the Security spec
+func (p *SecurityPolicy) Apply(spec *blendedv1.SecuritySpec) {
+	spec.SourceZones = p.SourceZones
+	spec.SourceAddresses = p.SourceAddresses
+	spec.DestinationZones = p.DestinationZones
+	spec.SourceUsers = p.SourceUsers
+	spec.HipProfiles = p.HipProfiles
+	spec.Applications = p.Applications
+	spec.Categories = p.Categories
+	spec.Action = p.Action
+	spec.LogSetting = p.LogSetting
+	spec.Group = p.Group
+}
+
+// ForAddress returns the policy for the destination public IP, the whitelist only keeps the addresses
+// of the same family and the referenced PA objects. If nothing is kept, the traffic of the family is denied.
+func (p *SecurityPolicy) ForAddress(addr string) *SecurityPolicy {
+	if funk.ContainsString(p.SourceAddresses, "any") {
+		return p
+	}
+
+	v6 := isIPv6(addr)
+	policy := p.copy()
+	policy.SourceAddresses = funk.FilterString(p.SourceAddresses, func(s string) bool {
+		return isAddressName(s) || isIPv6(s) == v6
+	})
+
+	if len(policy.SourceAddresses) == 0 {
+		policy.SourceAddresses = []string{"any"}
+		policy.Action = blendedv1.SecurityDeny
+	}
+	return policy
+}
+
+func overrideList(field *[]string, list []string) {
+	if len(list) > 0 {
+		*field = copyList(list)
+	}
+}
+
+func overrideName(field *string, name string) {
+	if len(name) > 0 {
+		*field = name
+	}
+}
+
+func overrideLists(annotations map[string]string, fields map[string]*[]string) error {
+	for key, field := range fields {
+		value, ok := annotations[key]
+		if !ok {
+			continue
+		}
+
+		list, err := parseList(value)
+		if err != nil {
+			return fmt.Errorf("invalid annotation '%s': %s", key, err.Error())
+		}
+		*field = list
+	}
+	return nil
+}
+
+func overrideNames(annotations map[string]string, fields map[string]*string) error {
+	for key, field := range fields {
+		value, ok := annotations[key]
+		if !ok {
+			continue
+		}
+
+		name := strings.TrimSpace(value)
+		if strings.Contains(name, ",") {
+			return fmt.Errorf("invalid annotation '%s': only one name is allowed", key)
+		}
+		*field = name
+	}
+	return nil
+}
+
+// parseList parses the comma-separated list, the items can't be empty
+func parseList(value string) ([]string, error) {
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			return nil, fmt.Errorf("empty item in '%s'", value)
+		}
+		list = append(list, item)
+	}
+	return list, nil
+}
+
+// mergeList returns the union of lists, "any" takes over the other items
+func mergeList(a, b []string) []string {
+	if funk.ContainsString(a, "any") || funk.ContainsString(b, "any") {
+		return []string{"any"}
+	}
+	return funk.UniqString(append(copyList(a), b...))
+}
+
+func copyList(list []string) []string {
+	if list == nil {
+		return nil
+	}
+	return append([]string{}, list...)
+}
+
+// intersectAddresses returns the addresses which are allowed by both of the whitelists. Since two
+// networks are either nested or disjoint, the intersection consists of the narrower one of each
+// nested pair.
+func intersectAddresses(a, b []string) []string {
+	if funk.ContainsString(a, "any") {
+		return copyList(b)
+	}
+
+	if funk.ContainsString(b, "any") {
+		return copyList(a)
+	}
+
+	addresses := []string{}
+	for _, x := range a {
+		for _, y := range b {
+			// The referenced PA objects are only allowed by the same reference
+			if x == y {
+				addresses = append(addresses, x)
+				continue
+			}
+
+			xnet, ynet := toIPNet(x), toIPNet(y)
+			if xnet == nil || ynet == nil {
+				continue
+			}
+
+			switch {
+			case containsNet(ynet, xnet):
+				addresses = append(addresses, x)
+			case containsNet(xnet, ynet):
+				addresses = append(addresses, y)
+			}
+		}
+	}
+	return funk.UniqString(addresses)
+}
+
+// containsNet checks whether the inner network is a subnet of the outer network
+func containsNet(outer, inner *net.IPNet) bool {
+	outerOnes, outerBits := outer.Mask.Size()
+	innerOnes, innerBits := inner.Mask.Size()
+	return outerBits == innerBits && outerOnes <= innerOnes && outer.Contains(inner.IP)
+}
+
+// toIPNet converts the IP or CIDR address to network, the IP is regarded as a host network
+func toIPNet(addr string) *net.IPNet {
+	if ip := net.ParseIP(addr); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+	}
+
+	_, ipnet, err := net.ParseCIDR(addr)
+	if err != nil {
+		return nil
+	}
+	return ipnet
+}
@@ -0,0 +1,348 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"testing"
+
+	blendedv1 "github.com/inwinstack/blended/apis/inwinstack/v1"
+	"github.com/inwinstack/pa-svc-syncker/pkg/config"
+	"github.com/inwinstack/pa-svc-syncker/pkg/constants"
+	"github.com/inwinstack/pa-svc-syncker/pkg/syncpolicy"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNamespaceSecurityPolicy(t *testing.T) {
+	cfg := &config.Config{
+		SourceZones:      []string{"untrust"},
+		DestinationZones: []string{"AI public service network"},
+		SourceUsers:      []string{"any"},
+		HipProfiles:      []string{"any"},
+		Applications:     []string{"any"},
+		Categories:       []string{"any"},
+		LogSettingName:   "default-log",
+		GroupName:        "default-group",
+	}
+
+	tests := []struct {
+		Annotations map[string]string
+		Policy      *SecurityPolicy
+	}{
+		{
+			Annotations: nil,
+			Policy: &SecurityPolicy{
+				SourceZones:      []string{"untrust"},
+				SourceAddresses:  []string{"any"},
+				DestinationZones: []string{"AI public service network"},
+				SourceUsers:      []string{"any"},
+				HipProfiles:      []string{"any"},
+				Applications:     []string{"any"},
+				Categories:       []string{"any"},
+				Action:           "allow",
+				LogSetting:       "default-log",
+				Group:            "default-group",
+			},
+		},
+		{
+			Annotations: map[string]string{
+				constants.SecurityDestinationZonesKey: "tenant zone, dmz",
+				constants.SecurityApplicationsKey:     "web-browsing,ssl",
+				constants.SecurityLogSettingKey:       " tenant-log ",
+				constants.SecurityGroupKey:            "",
+			},
+			Policy: &SecurityPolicy{
+				SourceZones:      []string{"untrust"},
+				SourceAddresses:  []string{"any"},
+				DestinationZones: []string{"tenant zone", "dmz"},
+				SourceUsers:      []string{"any"},
+				HipProfiles:      []string{"any"},
+				Applications:     []string{"web-browsing", "ssl"},
+				Categories:       []string{"any"},
+				Action:           "allow",
+				LogSetting:       "tenant-log",
+				Group:            "",
+			},
+		},
+		{
+			Annotations: map[string]string{constants.SecuritySourceZonesKey: "untrust,,trust"},
+			Policy:      nil,
+		},
+		{
+			Annotations: map[string]string{constants.SecurityLogSettingKey: "log1,log2"},
+			Policy:      nil,
+		},
+	}
+
+	for _, test := range tests {
+		ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test", Annotations: test.Annotations}}
+		policy, err := NamespaceSecurityPolicy(NewSecurityPolicy(cfg, nil), nil, ns)
+		assert.Equal(t, test.Policy == nil, err != nil)
+		assert.Equal(t, test.Policy, policy)
+	}
+}
+
+func TestServiceSecurityPolicy(t *testing.T) {
+	cfg := &config.Config{
+		SourceZones:      []string{"untrust"},
+		DestinationZones: []string{"AI public service network"},
+		SourceUsers:      []string{"any"},
+		HipProfiles:      []string{"any"},
+		Applications:     []string{"any"},
+		Categories:       []string{"any"},
+	}
+
+	ns := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "test",
+			Annotations: map[string]string{
+				constants.WhiteListAddressesKey:       "172.22.0.0/16,10.0.0.1",
+				constants.SecurityDestinationZonesKey: "tenant zone",
+				constants.SecurityLogSettingKey:       "tenant-log",
+				constants.SecurityGroupKey:            "tenant-group",
+			},
+		},
+	}
+
+	policy := &SecurityPolicy{
+		SourceZones:      []string{"untrust"},
+		SourceAddresses:  []string{"10.0.0.1", "172.22.0.0/16"},
+		DestinationZones: []string{"tenant zone"},
+		SourceUsers:      []string{"any"},
+		HipProfiles:      []string{"any"},
+		Applications:     []string{"any"},
+		Categories:       []string{"any"},
+		Action:           "allow",
+		LogSetting:       "tenant-log",
+		Group:            "tenant-group",
+	}
+
+	newService := func(name string, annotations map[string]string) *corev1.Service {
+		return &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "test", Annotations: annotations}}
+	}
+
+	tests := []struct {
+		Owners []*corev1.Service
+		Policy *SecurityPolicy
+	}{
+		{
+			Owners: nil,
+			Policy: policy,
+		},
+		{
+			// The namespace policy is used when there are no annotations
+			Owners: []*corev1.Service{newService("svc1", nil)},
+			Policy: policy,
+		},
+		{
+			// The service annotations take precedence, and the whitelist is narrowed
+			Owners: []*corev1.Service{
+				newService("svc1", map[string]string{
+					constants.SecurityApplicationsKey: "web-browsing,ssl",
+					constants.SecurityCategoriesKey:   "business",
+					constants.SecurityActionKey:       "deny",
+					constants.SecurityLogSettingKey:   "svc-log",
+					constants.WhiteListAddressesKey:   "172.22.1.0/24,172.0.0.0/8,10.0.0.2",
+				}),
+			},
+			Policy: &SecurityPolicy{
+				SourceZones:      []string{"untrust"},
+				SourceAddresses:  []string{"172.22.0.0/16"},
+				DestinationZones: []string{"tenant zone"},
+				SourceUsers:      []string{"any"},
+				HipProfiles:      []string{"any"},
+				Applications:     []string{"web-browsing", "ssl"},
+				Categories:       []string{"business"},
+				Action:           "deny",
+				LogSetting:       "svc-log",
+				Group:            "tenant-group",
+			},
+		},
+		{
+			// The lists of services sharing the public IP are merged
+			Owners: []*corev1.Service{
+				newService("svc1", map[string]string{
+					constants.SecurityApplicationsKey: "web-browsing",
+					constants.WhiteListAddressesKey:   "172.22.1.1",
+				}),
+				newService("svc2", map[string]string{
+					constants.SecurityApplicationsKey: "ssl,web-browsing",
+					constants.WhiteListAddressesKey:   "10.0.0.1",
+				}),
+			},
+			Policy: &SecurityPolicy{
+				SourceZones:      []string{"untrust"},
+				SourceAddresses:  []string{"172.22.1.1", "10.0.0.1"},
+				DestinationZones: []string{"tenant zone"},
+				SourceUsers:      []string{"any"},
+				HipProfiles:      []string{"any"},
+				Applications:     []string{"web-browsing", "ssl"},
+				Categories:       []string{"any"},
+				Action:           "allow",
+				LogSetting:       "tenant-log",
+				Group:            "tenant-group",
+			},
+		},
+		{
+			// The "any" takes over the other items
+			Owners: []*corev1.Service{
+				newService("svc1", map[string]string{constants.SecurityApplicationsKey: "ssl"}),
+				newService("svc2", nil),
+			},
+			Policy: policy,
+		},
+		{
+			// The whitelist doesn't overlap with the namespace whitelist
+			Owners: []*corev1.Service{newService("svc1", map[string]string{constants.WhiteListAddressesKey: "192.168.0.0/24"})},
+			Policy: nil,
+		},
+		{
+			Owners: []*corev1.Service{newService("svc1", map[string]string{constants.SecurityActionKey: "drop"})},
+			Policy: nil,
+		},
+		{
+			Owners: []*corev1.Service{
+				newService("svc1", map[string]string{constants.SecurityActionKey: "deny"}),
+				newService("svc2", nil),
+			},
+			Policy: nil,
+		},
+		{
+			Owners: []*corev1.Service{
+				newService("svc1", map[string]string{constants.SecurityLogSettingKey: "svc1-log"}),
+				newService("svc2", map[string]string{constants.SecurityLogSettingKey: "svc2-log"}),
+			},
+			Policy: nil,
+		},
+	}
+
+	for _, test := range tests {
+		result, err := ServiceSecurityPolicy(cfg, nil, nil, ns, test.Owners)
+		assert.Equal(t, test.Policy == nil, err != nil)
+		assert.Equal(t, test.Policy, result)
+	}
+}
+
+func TestIntersectAddresses(t *testing.T) {
+	tests := []struct {
+		A        []string
+		B        []string
+		Expected []string
+	}{
+		{A: []string{"any"}, B: []string{"10.0.0.1"}, Expected: []string{"10.0.0.1"}},
+		{A: []string{"10.0.0.0/8"}, B: []string{"any"}, Expected: []string{"10.0.0.0/8"}},
+		{A: []string{"10.0.0.0/8"}, B: []string{"10.1.0.0/16", "10.2.3.4"}, Expected: []string{"10.1.0.0/16", "10.2.3.4"}},
+		{A: []string{"10.1.0.0/16", "10.2.3.4"}, B: []string{"10.0.0.0/8"}, Expected: []string{"10.1.0.0/16", "10.2.3.4"}},
+		{A: []string{"10.0.0.1"}, B: []string{"10.0.0.1/32"}, Expected: []string{"10.0.0.1"}},
+		{A: []string{"10.0.0.0/24"}, B: []string{"192.168.0.0/24"}, Expected: []string{}},
+		{A: []string{"2001:db8::/32", "10.0.0.0/8"}, B: []string{"2001:db8:1::/48"}, Expected: []string{"2001:db8:1::/48"}},
+		{A: []string{"office-vpn", "10.0.0.1"}, B: []string{"10.0.0.0/8", "office-vpn"}, Expected: []string{"office-vpn", "10.0.0.1"}},
+		{A: []string{"office-vpn"}, B: []string{"10.0.0.0/8"}, Expected: []string{}},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.Expected, intersectAddresses(test.A, test.B))
+	}
+}
+
+func TestNewSecurityPolicy(t *testing.T) {
+	cfg := &config.Config{
+		SourceZones:      []string{"untrust"},
+		DestinationZones: []string{"AI public service network"},
+		SourceUsers:      []string{"any"},
+		HipProfiles:      []string{"any"},
+		Applications:     []string{"any"},
+		Categories:       []string{"any"},
+		LogSettingName:   "default-log",
+	}
+
+	sp := &syncpolicy.SyncPolicy{
+		Spec: syncpolicy.SyncPolicySpec{
+			Security: syncpolicy.SecurityPolicy{
+				DestinationZones: []string{"tenant zone"},
+				Applications:     []string{"web-browsing"},
+				Group:            "tenant-group",
+			},
+		},
+	}
+
+	expected := &SecurityPolicy{
+		SourceZones:      []string{"untrust"},
+		SourceAddresses:  []string{"any"},
+		DestinationZones: []string{"tenant zone"},
+		SourceUsers:      []string{"any"},
+		HipProfiles:      []string{"any"},
+		Applications:     []string{"web-browsing"},
+		Categories:       []string{"any"},
+		Action:           "allow",
+		LogSetting:       "default-log",
+		Group:            "tenant-group",
+	}
+	assert.Equal(t, expected, NewSecurityPolicy(cfg, sp))
+	assert.Equal(t, cfg.DestinationZones, NewSecurityPolicy(cfg, nil).DestinationZones)
+}
+
+func TestSecurityPolicyForAddress(t *testing.T) {
+	policy := &SecurityPolicy{
+		SourceAddresses: []string{"172.22.132.99", "2001:db8::/32"},
+		Action:          blendedv1.SecurityAllow,
+	}
+
+	v4 := policy.ForAddress("140.11.22.33")
+	assert.Equal(t, []string{"172.22.132.99"}, v4.SourceAddresses)
+	assert.Equal(t, blendedv1.SecurityAllow, v4.Action)
+
+	v6 := policy.ForAddress("2001:db8:ffff::1")
+	assert.Equal(t, []string{"2001:db8::/32"}, v6.SourceAddresses)
+	assert.Equal(t, []string{"172.22.132.99", "2001:db8::/32"}, policy.SourceAddresses)
+
+	// The whitelist without IPv6 address denies the IPv6 traffic
+	policy.SourceAddresses = []string{"172.22.132.99"}
+	denied := policy.ForAddress("2001:db8:ffff::1")
+	assert.Equal(t, []string{"any"}, denied.SourceAddresses)
+	assert.Equal(t, blendedv1.SecurityDeny, denied.Action)
+
+	// The referenced PA objects are kept for both families
+	policy.SourceAddresses = []string{"172.22.132.99", "office-vpn"}
+	assert.Equal(t, []string{"office-vpn"}, policy.ForAddress("2001:db8:ffff::1").SourceAddresses)
+	assert.Equal(t, []string{"172.22.132.99", "office-vpn"}, policy.ForAddress("140.11.22.33").SourceAddresses)
+
+	policy.SourceAddresses = []string{"any"}
+	assert.Equal(t, []string{"any"}, policy.ForAddress("2001:db8:ffff::1").SourceAddresses)
+}
+
+func TestSecurityAnnotationsChanged(t *testing.T) {
+	old := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Annotations: map[string]string{constants.WhiteListAddressesKey: "172.22.132.99"},
+	}}
+
+	new := old.DeepCopy()
+	new.Annotations["description"] = "test"
+	assert.False(t, SecurityAnnotationsChanged(old, new))
+
+	new.Annotations[constants.WhiteListAddressesKey] = "172.22.132.0/24"
+	assert.True(t, SecurityAnnotationsChanged(old, new))
+
+	new = old.DeepCopy()
+	new.Annotations[constants.SecurityLogSettingKey] = "tenant-log"
+	assert.True(t, SecurityAnnotationsChanged(old, new))
+
+	new = old.DeepCopy()
+	delete(new.Annotations, constants.WhiteListAddressesKey)
+	assert.True(t, SecurityAnnotationsChanged(old, new))
+}
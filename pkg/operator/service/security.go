@@ -18,96 +18,153 @@ package service
 
 import (
 	"fmt"
-	"net"
 	"strings"
 
+	"github.com/golang/glog"
 	blendedv1 "github.com/inwinstack/blended/apis/inwinstack/v1"
 	"github.com/inwinstack/pa-svc-syncker/pkg/constants"
+	"github.com/inwinstack/pa-svc-syncker/pkg/metrics"
+	"github.com/inwinstack/pa-svc-syncker/pkg/plan"
+	"github.com/inwinstack/pa-svc-syncker/pkg/whitelistset"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/kubernetes"
+	listerv1 "k8s.io/client-go/listers/core/v1"
 )
 
-func (c *Controller) newSecurity(name, addr string, sourceAddresses []string, svc *v1.Service) *blendedv1.Security {
-	return &blendedv1.Security{
+const securityDescription = "Automatically sync Security for Kubernetes service."
+
+func (c *Controller) newSecurity(name, addr string, services []string, policy *SecurityPolicy, owners []*v1.Service, svc *v1.Service) *blendedv1.Security {
+	sec := &blendedv1.Security{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      name,
-			Namespace: svc.Namespace,
+			Name:            name,
+			Namespace:       svc.Namespace,
+			Labels:          newLabels(addr, owners),
+			OwnerReferences: newOwnerReferences(owners),
 		},
 		Spec: blendedv1.SecuritySpec{
-			SourceZones:                     c.cfg.SourceZones,
-			SourceAddresses:                 sourceAddresses,
-			SourceUsers:                     c.cfg.SourceUsers,
-			HipProfiles:                     c.cfg.HipProfiles,
-			DestinationZones:                c.cfg.DestinationZones,
 			DestinationAddresses:            []string{addr},
-			Applications:                    c.cfg.Applications,
-			Services:                        c.cfg.Services,
-			Categories:                      c.cfg.Applications,
-			Action:                          "allow",
+			Services:                        services,
 			IcmpUnreachable:                 false,
 			DisableServerResponseInspection: false,
 			LogEnd:                          true,
-			LogSetting:                      c.cfg.LogSettingName,
-			Group:                           c.cfg.GroupName,
-			Description:                     "Automatically sync Security for Kubernetes service.",
+			Description:                     securityDescription,
 		},
 	}
+	policy.ForAddress(addr).Apply(&sec.Spec)
+	return sec
 }
 
-func (c *Controller) createSecurity(addr string, svc *v1.Service) error {
-	name := fmt.Sprintf("%s-%s", constants.PolicyPrefix, addr)
-	if _, err := c.blendedset.InwinstackV1().Securities(svc.Namespace).Get(name, metav1.GetOptions{}); err == nil {
-		return nil
+func (c *Controller) syncSecurity(addr string, services []string, owners []*v1.Service, svc *v1.Service) error {
+	if protocols := unsupportedProtocols(owners); len(protocols) > 0 {
+		c.recorder.Eventf(svc, v1.EventTypeWarning, constants.UnsupportedProtocolReason, "Skipped the %s ports of public IP '%s' which are not supported", strings.Join(protocols, ","), addr)
 	}
 
-	sources, err := ParseAddresses(c.clientset, svc.Namespace)
+	// The Security without any service object would allow all services, so it is removed instead
+	if len(services) == 0 {
+		glog.Warningf("Service controller skipped the Security of public IP '%s' which has no TCP or UDP port", addr)
+		return c.deleteSecurity(addr, svc)
+	}
+
+	name := policyName(addr)
+	ns, err := c.nsLister.Get(svc.Namespace)
 	if err != nil {
 		return err
 	}
 
-	sec := c.newSecurity(name, addr, sources, svc)
-	if _, err := c.blendedset.InwinstackV1().Securities(svc.Namespace).Create(sec); err != nil {
+	policy, err := ServiceSecurityPolicy(c.cfg.Get(), c.policies, c.whitelists, ns, owners)
+	if err != nil {
 		return err
 	}
+
+	sec := c.newSecurity(name, addr, services, policy, owners, svc)
+	current, err := c.blendedset.InwinstackV1().Securities(svc.Namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+
+		if c.dryRun(plan.NewCreate(plan.KindSecurity, sec.Namespace, name, sec)) {
+			return nil
+		}
+
+		if _, err := c.blendedset.InwinstackV1().Securities(svc.Namespace).Create(sec); err != nil {
+			return err
+		}
+		c.recorder.Eventf(svc, v1.EventTypeNormal, constants.SecurityCreatedReason, "Created Security '%s'", name)
+		return nil
+	}
+
+	if !isOwned(current.ObjectMeta, current.Spec.Description, securityDescription) {
+		return fmt.Errorf("Security '%s/%s' is not managed by syncker", svc.Namespace, name)
+	}
+
+	currentCopy := current.DeepCopy()
+	metaDrifted := syncMeta(&currentCopy.ObjectMeta, sec.ObjectMeta)
+	specDrift := syncSecuritySpec(&currentCopy.Spec, &sec.Spec)
+	drifted := append(metaDrifted, specDrift.fields()...)
+	if len(drifted) == 0 {
+		glog.V(4).Infof("Service controller skipped updating Security '%s/%s' which is up to date", svc.Namespace, name)
+		metrics.ObjectUpdates.WithLabelValues(plan.KindSecurity, metrics.UpdateSkipped).Inc()
+		c.plan.Resolve(plan.KindSecurity, svc.Namespace, name)
+		return nil
+	}
+
+	diff := append(plan.Diff(&current.ObjectMeta, &currentCopy.ObjectMeta, metaDrifted), specDrift.diffs...)
+	if c.dryRun(plan.NewUpdate(plan.KindSecurity, svc.Namespace, name, diff)) {
+		return nil
+	}
+
+	glog.Infof("Service controller detected drift on Security '%s/%s': %s", svc.Namespace, name, strings.Join(drifted, ", "))
+	if _, err := c.blendedset.InwinstackV1().Securities(svc.Namespace).Update(currentCopy); err != nil {
+		return err
+	}
+	metrics.ObjectUpdates.WithLabelValues(plan.KindSecurity, metrics.UpdateWritten).Inc()
+	c.recorder.Eventf(svc, v1.EventTypeNormal, constants.SecurityUpdatedReason, "Updated drifted Security '%s': %s", name, strings.Join(drifted, ", "))
 	return nil
 }
 
 func (c *Controller) deleteSecurity(addr string, svc *v1.Service) error {
-	name := fmt.Sprintf("%s-%s", constants.PolicyPrefix, addr)
-	if _, err := c.blendedset.InwinstackV1().Securities(svc.Namespace).Get(name, metav1.GetOptions{}); err != nil {
-		if strings.Contains(err.Error(), "not found") {
+	name := policyName(addr)
+	sec, err := c.blendedset.InwinstackV1().Securities(svc.Namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			c.plan.Resolve(plan.KindSecurity, svc.Namespace, name)
 			return nil
 		}
 		return err
 	}
+
+	if !isOwned(sec.ObjectMeta, sec.Spec.Description, securityDescription) {
+		glog.Warningf("Service controller skipped deleting Security '%s/%s' which is not managed by syncker", svc.Namespace, name)
+		return nil
+	}
+
+	if c.dryRun(plan.NewDelete(plan.KindSecurity, svc.Namespace, name)) {
+		return nil
+	}
 	return c.blendedset.InwinstackV1().Securities(svc.Namespace).Delete(name, nil)
 }
 
-// ParseAddresses parses the whitelist IP address from Namespace's annotation
-func ParseAddresses(clientset kubernetes.Interface, namespace string) ([]string, error) {
-	sourceAddresses := []string{"any"}
-	ns, err := clientset.CoreV1().Namespaces().Get(namespace, metav1.GetOptions{})
+// ParseAddresses parses the whitelist IP address from Namespace's annotations, the referenced WhitelistSets
+// are merged into the whitelist
+func ParseAddresses(lister listerv1.NamespaceLister, sets *whitelistset.Store, namespace string) ([]string, error) {
+	ns, err := lister.Get(namespace)
 	if err != nil {
 		return nil, err
 	}
+	return ParseWhitelist(sets, ns)
+}
 
-	if value, ok := ns.Annotations[constants.WhiteListAddressesKey]; ok {
-		if s := strings.TrimSpace(value); len(s) > 0 {
-			addresses := strings.Split(s, ",")
-			for _, addr := range addresses {
-				ip := net.ParseIP(addr)
-				if ip != nil {
-					continue
-				}
-
-				_, _, err := net.ParseCIDR(addr)
-				if err != nil {
-					return nil, err
-				}
-			}
-			sourceAddresses = addresses
-		}
+// ParseWhitelist parses the whitelist IP address from the annotations of Namespace object
+func ParseWhitelist(sets *whitelistset.Store, ns *v1.Namespace) ([]string, error) {
+	value, ok, err := whitelistValue(sets, ns)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return []string{"any"}, nil
 	}
-	return sourceAddresses, nil
+	return parseWhitelist(value)
 }
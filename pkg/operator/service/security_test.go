@@ -17,23 +17,33 @@ limitations under the License.
 package service
 
 import (
+	"context"
 	"testing"
 
 	"github.com/inwinstack/pa-svc-syncker/pkg/constants"
+	"github.com/inwinstack/pa-svc-syncker/pkg/whitelistset"
 	"github.com/stretchr/testify/assert"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes/fake"
+	listerv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
 )
 
 func TestParseAddresses(t *testing.T) {
-	clientset := fake.NewSimpleClientset()
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	lister := listerv1.NewNamespaceLister(indexer)
 	tests := []struct {
 		Addresses []string
 		Namespace *corev1.Namespace
 	}{
 		{
-			Addresses: []string{"172.22.132.99", "172.22.131.0/32"},
+			Addresses: []string{"172.22.131.0", "172.22.132.99"},
 			Namespace: &corev1.Namespace{
 				ObjectMeta: metav1.ObjectMeta{
 					Name: "test1",
@@ -68,10 +78,205 @@ func TestParseAddresses(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		_, nserr := clientset.CoreV1().Namespaces().Create(test.Namespace)
-		assert.Nil(t, nserr)
+		assert.Nil(t, indexer.Add(test.Namespace))
 
-		sourceAddresses, _ := ParseAddresses(clientset, test.Namespace.Name)
+		sourceAddresses, _ := ParseAddresses(lister, nil, test.Namespace.Name)
 		assert.Equal(t, test.Addresses, sourceAddresses)
 	}
 }
+
+func TestParseWhitelist(t *testing.T) {
+	tests := []struct {
+		Value     string
+		Addresses []string
+		Error     bool
+	}{
+		{Value: " \n ", Addresses: []string{"any"}},
+		{Value: "172.22.132.99, 172.22.131.5/24\n172.22.132.99", Addresses: []string{"172.22.131.0/24", "172.22.132.99"}},
+		{Value: "10.0.0.0/25 10.0.0.128/25,10.0.0.7", Addresses: []string{"10.0.0.0/24"}},
+		{Value: "10.0.0.1-10.0.0.6", Addresses: []string{"10.0.0.1", "10.0.0.2/31", "10.0.0.4/31", "10.0.0.6"}},
+		{Value: "2001:DB8::-2001:db8::ff", Addresses: []string{"2001:db8::/120"}},
+		{Value: "10.0.0.0/24,!10.0.0.0/25,!10.0.0.255", Addresses: []string{"10.0.0.128/26", "10.0.0.192/27", "10.0.0.224/28", "10.0.0.240/29", "10.0.0.248/30", "10.0.0.252/31", "10.0.0.254"}},
+		{Value: "group:office-vpn 10.0.0.1 address:web_01 group:office-vpn !address:web_01", Addresses: []string{"10.0.0.1", "office-vpn"}},
+		{Value: "10.0.0.1,2001:db8::1,10.0.0.0", Addresses: []string{"10.0.0.0/31", "2001:db8::1"}},
+		{Value: "10.0.0.6-10.0.0.1", Error: true},
+		{Value: "10.0.0.1-2001:db8::1", Error: true},
+		{Value: "10.0.0.1-", Error: true},
+		{Value: "group:", Error: true},
+		{Value: "group:-vpn", Error: true},
+		{Value: "office-vpn", Error: true},
+		{Value: "!", Error: true},
+		{Value: "10.0.0.0/24,!10.0.0.0/16", Error: true},
+		// An exclusion never widens the whitelist to any address
+		{Value: "!10.0.0.0/8", Error: true},
+		{Value: "!0.0.0.0/1", Error: true},
+		{Value: "!group:office-vpn", Error: true},
+		{Value: "!address:web_01", Error: true},
+		{Value: "10.0.0.0/8 !group:office-vpn", Error: true},
+	}
+
+	for _, test := range tests {
+		addresses, err := parseWhitelist(test.Value)
+		assert.Equal(t, test.Error, err != nil, test.Value)
+		assert.Equal(t, test.Addresses, addresses, test.Value)
+	}
+}
+
+func TestParseWhitelistSets(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	newSet := func(name string, addresses ...string) runtime.Object {
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&whitelistset.WhitelistSet{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "inwinstack.com/v1", Kind: "WhitelistSet"},
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       whitelistset.WhitelistSetSpec{Addresses: addresses},
+		})
+		assert.Nil(t, err)
+		return &unstructured.Unstructured{Object: content}
+	}
+
+	dynamicset := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(),
+		newSet("office", "172.22.0.0/16", "group:office-vpn"),
+		newSet("lab", "10.0.0.0/24"),
+		newSet("empty"),
+	)
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(dynamicset, 0)
+	sets := whitelistset.NewStore(factory.ForResource(whitelistset.GroupVersionResource))
+	go factory.Start(ctx.Done())
+	assert.True(t, cache.WaitForCacheSync(ctx.Done(), sets.HasSynced))
+
+	tests := []struct {
+		Annotations map[string]string
+		Addresses   []string
+	}{
+		{
+			Annotations: map[string]string{constants.WhitelistSetsKey: "office, lab"},
+			Addresses:   []string{"10.0.0.0/24", "172.22.0.0/16", "office-vpn"},
+		},
+		{
+			Annotations: map[string]string{
+				constants.WhitelistSetsKey:      "lab",
+				constants.WhiteListAddressesKey: "192.168.0.1 !10.0.0.0/25",
+			},
+			Addresses: []string{"10.0.0.128/25", "192.168.0.1"},
+		},
+		{
+			Annotations: map[string]string{constants.WhitelistSetsKey: ""},
+			Addresses:   []string{"any"},
+		},
+		{Annotations: map[string]string{constants.WhitelistSetsKey: "unknown"}},
+		{Annotations: map[string]string{constants.WhitelistSetsKey: "empty"}},
+	}
+
+	for _, test := range tests {
+		ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test", Annotations: test.Annotations}}
+		addresses, err := ParseWhitelist(sets, ns)
+		assert.Equal(t, test.Addresses == nil, err != nil)
+		assert.Equal(t, test.Addresses, addresses)
+	}
+
+	// The references are rejected if WhitelistSet is disabled
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{constants.WhitelistSetsKey: "lab"}}}
+	_, err := ParseWhitelist(nil, ns)
+	assert.NotNil(t, err)
+}
+
+func TestWhitelistCache(t *testing.T) {
+	informer := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0).Core().V1().Namespaces()
+	whitelists := NewWhitelistCache(informer, nil)
+
+	ns := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test1",
+			Annotations: map[string]string{constants.WhiteListAddressesKey: "172.22.132.99"},
+		},
+	}
+	assert.Nil(t, informer.Informer().GetIndexer().Add(ns))
+
+	addresses, err := whitelists.Get(ns.Name)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"172.22.132.99"}, addresses)
+
+	// The returned list can't change the cache
+	addresses[0] = "10.0.0.1"
+	addresses, err = whitelists.Get(ns.Name)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"172.22.132.99"}, addresses)
+
+	// The changed annotation is parsed again
+	ns = ns.DeepCopy()
+	ns.Annotations[constants.WhiteListAddressesKey] = "172.22.132.0/33"
+	assert.Nil(t, informer.Informer().GetIndexer().Update(ns))
+	_, err = whitelists.Get(ns.Name)
+	assert.NotNil(t, err)
+
+	delete(ns.Annotations, constants.WhiteListAddressesKey)
+	addresses, err = whitelists.Parse(ns)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"any"}, addresses)
+
+	_, err = whitelists.Get("test2")
+	assert.NotNil(t, err)
+
+	// The nil cache parses the annotation
+	var nilCache *WhitelistCache
+	addresses, err = nilCache.Parse(&corev1.Namespace{})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"any"}, addresses)
+}
+
+// BenchmarkResyncWhitelist logs the API calls for getting the whitelists of services in a resync, which
+// were the live gets of namespace for each service before, and are served by the informer cache now.
+func BenchmarkResyncWhitelist(b *testing.B) {
+	const services = 100
+	ns := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test1",
+			Annotations: map[string]string{constants.WhiteListAddressesKey: "172.22.132.99,172.22.131.0/24"},
+		},
+	}
+
+	b.Run("client", func(b *testing.B) {
+		clientset := fake.NewSimpleClientset(ns)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			for j := 0; j < services; j++ {
+				n, err := clientset.CoreV1().Namespaces().Get(ns.Name, metav1.GetOptions{})
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				if _, err := ParseWhitelist(nil, n); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+		b.StopTimer()
+		b.Logf("%.0f apicalls/resync", float64(len(clientset.Actions()))/float64(b.N))
+	})
+
+	b.Run("lister", func(b *testing.B) {
+		stopCh := make(chan struct{})
+		defer close(stopCh)
+
+		clientset := fake.NewSimpleClientset(ns)
+		factory := informers.NewSharedInformerFactory(clientset, 0)
+		whitelists := NewWhitelistCache(factory.Core().V1().Namespaces(), nil)
+		factory.Start(stopCh)
+		factory.WaitForCacheSync(stopCh)
+
+		// The list and watch of informer are made once at startup
+		clientset.ClearActions()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			for j := 0; j < services; j++ {
+				if _, err := whitelists.Get(ns.Name); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+		b.StopTimer()
+		b.Logf("%.0f apicalls/resync", float64(len(clientset.Actions()))/float64(b.N))
+	})
+}
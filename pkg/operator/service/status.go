@@ -0,0 +1,116 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"github.com/inwinstack/pa-svc-syncker/pkg/constants"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// These are the valid phases of a sync status.
+const (
+	SyncPhaseSynced = "Synced"
+	SyncPhaseFailed = "Failed"
+)
+
+// SyncStatus represents the sync result of a service, which is recorded in the annotation of service.
+type SyncStatus struct {
+	Phase              string      `json:"phase"`
+	Reason             string      `json:"reason,omitempty"`
+	ObservedGeneration int64       `json:"observedGeneration"`
+	LastTransitionTime metav1.Time `json:"lastTransitionTime"`
+	NATs               []string    `json:"nats,omitempty"`
+	Securities         []string    `json:"securities,omitempty"`
+}
+
+// GetSyncStatus returns the sync status from the annotation of service
+func GetSyncStatus(svc *v1.Service) (*SyncStatus, error) {
+	status := &SyncStatus{}
+	value, ok := svc.Annotations[constants.SyncStatusKey]
+	if !ok {
+		return status, nil
+	}
+
+	if err := json.Unmarshal([]byte(value), status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// newSyncStatus returns the new sync status without the transition time. If failed to sync,
+// it keeps the names and generation that were last synced.
+func newSyncStatus(svc *v1.Service, addresses []string, syncErr error) *SyncStatus {
+	if syncErr != nil {
+		status, err := GetSyncStatus(svc)
+		if err != nil {
+			status = &SyncStatus{}
+		}
+		status.Phase = SyncPhaseFailed
+		status.Reason = syncErr.Error()
+		status.LastTransitionTime = metav1.Time{}
+		return status
+	}
+
+	status := &SyncStatus{
+		Phase:              SyncPhaseSynced,
+		ObservedGeneration: svc.Generation,
+	}
+	for _, addr := range addresses {
+		name := policyName(addr)
+		status.NATs = append(status.NATs, name)
+		status.Securities = append(status.Securities, name)
+	}
+	return status
+}
+
+// updateStatus records the sync status into the annotation of service when it changed. The status is compared
+// with the cached service, including the observed generation, and the transition time is only updated when the
+// other fields are changed, so the
+// service isn't written on every resync. The conflict of a stale cached service is retried by the queue.
+func (c *Controller) updateStatus(svc *v1.Service, addresses []string, syncErr error) error {
+	if c.plan != nil {
+		return nil
+	}
+
+	status := newSyncStatus(svc, addresses, syncErr)
+	if current, err := GetSyncStatus(svc); err == nil {
+		current.LastTransitionTime = metav1.Time{}
+		if reflect.DeepEqual(current, status) {
+			return nil
+		}
+	}
+
+	status.LastTransitionTime = metav1.Now()
+	b, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+
+	svcCopy := svc.DeepCopy()
+	if svcCopy.Annotations == nil {
+		svcCopy.Annotations = map[string]string{}
+	}
+	svcCopy.Annotations[constants.SyncStatusKey] = string(b)
+	if _, err := c.clientset.CoreV1().Services(svcCopy.Namespace).Update(svcCopy); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/inwinstack/pa-svc-syncker/pkg/constants"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestNewSyncStatus(t *testing.T) {
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "test-svc",
+			Namespace:  "test",
+			Generation: 3,
+		},
+	}
+
+	status := newSyncStatus(svc, []string{"140.11.22.33"}, nil)
+	assert.Equal(t, &SyncStatus{
+		Phase:              SyncPhaseSynced,
+		ObservedGeneration: 3,
+		NATs:               []string{"k8s-140.11.22.33"},
+		Securities:         []string{"k8s-140.11.22.33"},
+	}, status)
+
+	// The failed status keeps the last synced names and generation, and the transition time is reset
+	svc.Generation = 4
+	svc.Annotations = map[string]string{
+		constants.SyncStatusKey: `{"phase":"Synced","observedGeneration":3,"lastTransitionTime":"2019-05-01T10:00:00Z","nats":["k8s-140.11.22.33"],"securities":["k8s-140.11.22.33"]}`,
+	}
+	status = newSyncStatus(svc, nil, fmt.Errorf("failed to get the public IP"))
+	assert.Equal(t, &SyncStatus{
+		Phase:              SyncPhaseFailed,
+		Reason:             "failed to get the public IP",
+		ObservedGeneration: 3,
+		NATs:               []string{"k8s-140.11.22.33"},
+		Securities:         []string{"k8s-140.11.22.33"},
+	}, status)
+}
+
+func TestUpdateStatus(t *testing.T) {
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test-svc",
+			Namespace:   "test",
+			Generation:  1,
+			Annotations: map[string]string{},
+		},
+	}
+	clientset := fake.NewSimpleClientset(svc)
+	c := &Controller{clientset: clientset}
+
+	assert.Nil(t, c.updateStatus(svc, []string{"140.11.22.33"}, nil))
+	updated, err := clientset.CoreV1().Services(svc.Namespace).Get(svc.Name, metav1.GetOptions{})
+	assert.Nil(t, err)
+	status, err := GetSyncStatus(updated)
+	assert.Nil(t, err)
+	assert.Equal(t, SyncPhaseSynced, status.Phase)
+	assert.Equal(t, int64(1), status.ObservedGeneration)
+	assert.False(t, status.LastTransitionTime.IsZero())
+
+	// The unchanged status is compared with the given service without writing it again
+	clientset.ClearActions()
+	assert.Nil(t, c.updateStatus(updated, []string{"140.11.22.33"}, nil))
+	assert.Empty(t, clientset.Actions())
+
+	// The new generation is recorded even if the other fields are unchanged
+	updated.Generation = 2
+	assert.Nil(t, c.updateStatus(updated, []string{"140.11.22.33"}, nil))
+	assert.Len(t, clientset.Actions(), 1)
+	updated, err = clientset.CoreV1().Services(svc.Namespace).Get(svc.Name, metav1.GetOptions{})
+	assert.Nil(t, err)
+	status, err = GetSyncStatus(updated)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), status.ObservedGeneration)
+	clientset.ClearActions()
+
+	assert.Nil(t, c.updateStatus(updated, nil, fmt.Errorf("the public IP '140.11.22.33' is not active")))
+	assert.Len(t, clientset.Actions(), 1)
+	assert.Equal(t, "update", clientset.Actions()[0].GetVerb())
+}
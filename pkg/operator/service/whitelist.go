@@ -0,0 +1,265 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/inwinstack/pa-svc-syncker/pkg/constants"
+	"github.com/inwinstack/pa-svc-syncker/pkg/whitelistset"
+	"github.com/thoas/go-funk"
+	v1 "k8s.io/api/core/v1"
+	informerv1 "k8s.io/client-go/informers/core/v1"
+	listerv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// WhitelistCache caches the parsed whitelists of namespaces, which is shared by the service and namespace
+// controllers. The whitelist is parsed again only when the annotation of namespace or the addresses of
+// the referenced WhitelistSets are changed.
+type WhitelistCache struct {
+	lister listerv1.NamespaceLister
+	sets   *whitelistset.Store
+
+	mu    sync.Mutex
+	items map[string]*whitelistEntry
+}
+
+type whitelistEntry struct {
+	value     string
+	addresses []string
+	err       error
+}
+
+// NewWhitelistCache creates a whitelist cache of the namespaces from informer, the nil sets disables
+// WhitelistSet
+func NewWhitelistCache(informer informerv1.NamespaceInformer, sets *whitelistset.Store) *WhitelistCache {
+	c := &WhitelistCache{
+		lister: informer.Lister(),
+		sets:   sets,
+		items:  map[string]*whitelistEntry{},
+	}
+	informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+
+			if ns, ok := obj.(*v1.Namespace); ok {
+				c.forget(ns.Name)
+			}
+		},
+	})
+	return c
+}
+
+// HasSynced returns true when the WhitelistSet informer cache has been synced
+func (c *WhitelistCache) HasSynced() bool {
+	if c == nil {
+		return true
+	}
+	return c.sets.HasSynced()
+}
+
+// Get returns the whitelist of namespace from the lister
+func (c *WhitelistCache) Get(namespace string) ([]string, error) {
+	ns, err := c.lister.Get(namespace)
+	if err != nil {
+		return nil, err
+	}
+	return c.Parse(ns)
+}
+
+// Parse returns the whitelist of namespace, the nil cache parses the annotation every time
+func (c *WhitelistCache) Parse(ns *v1.Namespace) ([]string, error) {
+	if c == nil {
+		return ParseWhitelist(nil, ns)
+	}
+
+	value, ok, err := whitelistValue(c.sets, ns)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return []string{"any"}, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.items[ns.Name]
+	if !ok || entry.value != value {
+		addresses, err := parseWhitelist(value)
+		entry = &whitelistEntry{value: value, addresses: addresses, err: err}
+		c.items[ns.Name] = entry
+	}
+
+	if entry.err != nil {
+		return nil, entry.err
+	}
+	return copyList(entry.addresses), nil
+}
+
+func (c *WhitelistCache) forget(namespace string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.items, namespace)
+}
+
+// whitelistValue joins the whitelist annotation and the addresses of the referenced WhitelistSets, and
+// returns false if the namespace has neither of them
+func whitelistValue(sets *whitelistset.Store, ns *v1.Namespace) (string, bool, error) {
+	value, ok := ns.Annotations[constants.WhiteListAddressesKey]
+	names := WhitelistSetNames(ns)
+	if len(names) == 0 {
+		return value, ok, nil
+	}
+
+	entries := []string{value}
+	for _, name := range names {
+		set, err := sets.Get(name)
+		if err != nil {
+			return "", false, fmt.Errorf("invalid annotation '%s': %s", constants.WhitelistSetsKey, err.Error())
+		}
+		entries = append(entries, set.Spec.Addresses...)
+	}
+
+	// The empty whitelist means any address, which must not be the result of the empty sets
+	joined := strings.Join(entries, ",")
+	if len(strings.FieldsFunc(joined, isWhitelistSeparator)) == 0 {
+		return "", false, fmt.Errorf("invalid annotation '%s': no address in the WhitelistSets", constants.WhitelistSetsKey)
+	}
+	return joined, true, nil
+}
+
+// WhitelistSetNames returns the names of WhitelistSets referenced by the namespace
+func WhitelistSetNames(ns *v1.Namespace) []string {
+	names := []string{}
+	for _, name := range strings.Split(ns.Annotations[constants.WhitelistSetsKey], ",") {
+		if name = strings.TrimSpace(name); len(name) > 0 {
+			names = append(names, name)
+		}
+	}
+	return funk.UniqString(names)
+}
+
+// addressNamePrefixes are the prefixes of the references to PA address objects and address groups
+var addressNamePrefixes = []string{"address:", "group:"}
+
+// addressNameRegexp matches the names of PA objects, which are also separated by whitespaces in the whitelist
+var addressNameRegexp = regexp.MustCompile(`^[0-9A-Za-z][0-9A-Za-z._-]{0,62}$`)
+
+// parseWhitelist parses the IP, CIDR and "first-last" range addresses and the references to PA address
+// objects or groups, which are separated by commas or whitespaces. The entries prefixed with "!" are
+// excluded. The addresses are normalized to the minimal CIDRs, followed by the referenced names. The
+// empty value means any address. An exclusion never widens the whitelist, so the exclusions without
+// included addresses and the excluded names which aren't included are rejected.
+func parseWhitelist(value string) ([]string, error) {
+	entries := strings.FieldsFunc(value, isWhitelistSeparator)
+	if len(entries) == 0 {
+		return []string{"any"}, nil
+	}
+
+	included, excluded := []*ipRange{}, []*ipRange{}
+	names, excludedNames := []string{}, []string{}
+	for _, entry := range entries {
+		addr := strings.TrimPrefix(entry, "!")
+		exclusion := addr != entry
+
+		name, ok, err := parseAddressName(addr)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			if exclusion {
+				excludedNames = append(excludedNames, name)
+			} else {
+				names = append(names, name)
+			}
+			continue
+		}
+
+		r, err := parseIPRange(addr)
+		if err != nil {
+			return nil, err
+		}
+
+		if exclusion {
+			excluded = append(excluded, r)
+		} else {
+			included = append(included, r)
+		}
+	}
+
+	if len(included) == 0 && len(names) == 0 {
+		return nil, fmt.Errorf("no address is included for the exclusions")
+	}
+
+	// The content of PA objects is unknown, so a name can only be excluded from the included names
+	for _, name := range excludedNames {
+		if !funk.ContainsString(names, name) {
+			return nil, fmt.Errorf("the excluded address '%s' isn't included", name)
+		}
+	}
+
+	addresses := []string{}
+	for _, r := range subtractRanges(mergeRanges(included), excluded) {
+		addresses = append(addresses, r.cidrs()...)
+	}
+
+	for _, name := range funk.UniqString(names) {
+		if !funk.ContainsString(excludedNames, name) {
+			addresses = append(addresses, name)
+		}
+	}
+
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("no address is left after the exclusions")
+	}
+	return addresses, nil
+}
+
+func isWhitelistSeparator(r rune) bool {
+	return r == ',' || unicode.IsSpace(r)
+}
+
+// parseAddressName parses the reference to PA address object or group, and returns false if the address
+// isn't a reference
+func parseAddressName(addr string) (string, bool, error) {
+	for _, prefix := range addressNamePrefixes {
+		if !strings.HasPrefix(addr, prefix) {
+			continue
+		}
+
+		name := strings.TrimPrefix(addr, prefix)
+		if !addressNameRegexp.MatchString(name) {
+			return "", false, fmt.Errorf("invalid address name '%s'", addr)
+		}
+		return name, true, nil
+	}
+	return "", false, nil
+}
+
+// isAddressName checks whether the source address is a reference to PA address object or group
+func isAddressName(addr string) bool {
+	return addr != "any" && toIPNet(addr) == nil
+}
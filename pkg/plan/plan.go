@@ -0,0 +1,199 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plan
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Action is the action of the change
+type Action string
+
+// The actions of the change
+const (
+	ActionCreate Action = "create"
+	ActionUpdate Action = "update"
+	ActionDelete Action = "delete"
+)
+
+// The kinds of the blended objects
+const (
+	KindNAT      = "NAT"
+	KindSecurity = "Security"
+	KindService  = "Service"
+)
+
+// FieldDiff is the difference of a field between the current and the desired object
+type FieldDiff struct {
+	Field   string      `json:"field"`
+	Current interface{} `json:"current"`
+	Desired interface{} `json:"desired"`
+}
+
+// Diff returns the differences of the fields between the current and the desired struct, all fields
+// are compared if no field is given. The nil and the empty slices are regarded as equal.
+func Diff(current, desired interface{}, fields []string) []FieldDiff {
+	diffs := []FieldDiff{}
+	cv, dv := reflect.ValueOf(current).Elem(), reflect.ValueOf(desired).Elem()
+	if len(fields) == 0 {
+		for i := 0; i < cv.NumField(); i++ {
+			fields = append(fields, cv.Type().Field(i).Name)
+		}
+	}
+
+	for _, field := range fields {
+		cf, df := cv.FieldByName(field), dv.FieldByName(field)
+		if !cf.IsValid() || !df.IsValid() {
+			continue
+		}
+
+		if cf.Kind() == reflect.Slice && cf.Len() == 0 && df.Len() == 0 {
+			continue
+		}
+
+		if reflect.DeepEqual(cf.Interface(), df.Interface()) {
+			continue
+		}
+
+		diffs = append(diffs, FieldDiff{
+			Field:   field,
+			Current: cf.Interface(),
+			Desired: df.Interface(),
+		})
+	}
+	return diffs
+}
+
+// Change is the change which would be applied if the dry-run mode is disabled
+type Change struct {
+	Action    Action      `json:"action"`
+	Kind      string      `json:"kind"`
+	Namespace string      `json:"namespace,omitempty"`
+	Name      string      `json:"name"`
+	Diff      []FieldDiff `json:"diff,omitempty"`
+	Object    interface{} `json:"object,omitempty"`
+	Time      time.Time   `json:"time"`
+}
+
+// NewCreate returns the change for creating the object
+func NewCreate(kind, namespace, name string, obj interface{}) *Change {
+	return &Change{Action: ActionCreate, Kind: kind, Namespace: namespace, Name: name, Object: obj}
+}
+
+// NewUpdate returns the change for updating the fields of object
+func NewUpdate(kind, namespace, name string, diff []FieldDiff) *Change {
+	return &Change{Action: ActionUpdate, Kind: kind, Namespace: namespace, Name: name, Diff: diff}
+}
+
+// NewDelete returns the change for deleting the object
+func NewDelete(kind, namespace, name string) *Change {
+	return &Change{Action: ActionDelete, Kind: kind, Namespace: namespace, Name: name}
+}
+
+func (c *Change) key() string {
+	return strings.Join([]string{c.Kind, c.Namespace, c.Name}, "/")
+}
+
+func (c *Change) String() string {
+	name := c.Name
+	if c.Namespace != "" {
+		name = c.Namespace + "/" + c.Name
+	}
+
+	fields := []string{}
+	for _, diff := range c.Diff {
+		fields = append(fields, fmt.Sprintf("%s: %v -> %v", diff.Field, diff.Current, diff.Desired))
+	}
+
+	if len(fields) == 0 {
+		return fmt.Sprintf("%s %s '%s'", c.Action, c.Kind, name)
+	}
+	return fmt.Sprintf("%s %s '%s': %s", c.Action, c.Kind, name, strings.Join(fields, ", "))
+}
+
+// Plan collects the pending changes in dry-run mode. Each object has at most one change, which is
+// replaced by the latest reconcile and removed once the object is in sync. The nil Plan means the
+// dry-run mode is disabled.
+type Plan struct {
+	mux     sync.RWMutex
+	changes map[string]*Change
+}
+
+// New creates an instance of the plan
+func New() *Plan {
+	return &Plan{changes: map[string]*Change{}}
+}
+
+// Record records the change instead of applying it
+func (p *Plan) Record(change *Change) {
+	change.Time = time.Now()
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	key := change.key()
+	if old, ok := p.changes[key]; !ok || old.String() != change.String() {
+		glog.Infof("[dry-run] Would %s", change.String())
+	}
+	p.changes[key] = change
+}
+
+// Resolve removes the change of object, since the object is in sync
+func (p *Plan) Resolve(kind, namespace, name string) {
+	if p == nil {
+		return
+	}
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	delete(p.changes, (&Change{Kind: kind, Namespace: namespace, Name: name}).key())
+}
+
+// Changes returns the pending changes which are sorted by kind, namespace and name
+func (p *Plan) Changes() []*Change {
+	p.mux.RLock()
+	defer p.mux.RUnlock()
+
+	changes := make([]*Change, 0, len(p.changes))
+	for _, change := range p.changes {
+		changes = append(changes, change)
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].key() < changes[j].key()
+	})
+	return changes
+}
+
+// Handler returns the HTTP handler which serves the pending changes as JSON
+func (p *Plan) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(p.Changes()); err != nil {
+			glog.Errorf("Failed to encode the plan: %s", err.Error())
+		}
+	})
+}
@@ -0,0 +1,82 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plan
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiff(t *testing.T) {
+	type spec struct {
+		Zones       []string
+		Addresses   []string
+		Description string
+		Action      string
+	}
+
+	current := &spec{Zones: nil, Addresses: []string{"any"}, Description: "old", Action: "allow"}
+	desired := &spec{Zones: []string{}, Addresses: []string{"10.0.0.1"}, Description: "new", Action: "allow"}
+
+	assert.Equal(t, []FieldDiff{
+		{Field: "Addresses", Current: []string{"any"}, Desired: []string{"10.0.0.1"}},
+		{Field: "Description", Current: "old", Desired: "new"},
+	}, Diff(current, desired, nil))
+
+	assert.Equal(t, []FieldDiff{
+		{Field: "Description", Current: "old", Desired: "new"},
+	}, Diff(current, desired, []string{"Zones", "Description", "Action"}))
+
+	// The unknown fields are skipped instead of panicking
+	assert.Equal(t, []FieldDiff{}, Diff(current, desired, []string{"Unknown"}))
+}
+
+func TestPlan(t *testing.T) {
+	p := New()
+	p.Record(NewCreate(KindSecurity, "test", "k8s-140.145.20.10", nil))
+	p.Record(NewUpdate(KindNAT, "test", "k8s-140.145.20.10", []FieldDiff{{Field: "DatAddress", Current: "172.11.22.99", Desired: "172.11.22.38"}}))
+	p.Record(NewDelete(KindService, "", "k8s-140.145.20.11-tcp"))
+
+	// The latest change of the same object replaces the previous one
+	p.Record(NewDelete(KindSecurity, "test", "k8s-140.145.20.10"))
+
+	changes := p.Changes()
+	assert.Len(t, changes, 3)
+	assert.Equal(t, "update NAT 'test/k8s-140.145.20.10': DatAddress: 172.11.22.99 -> 172.11.22.38", changes[0].String())
+	assert.Equal(t, "delete Security 'test/k8s-140.145.20.10'", changes[1].String())
+	assert.Equal(t, "delete Service 'k8s-140.145.20.11-tcp'", changes[2].String())
+
+	p.Resolve(KindNAT, "test", "k8s-140.145.20.10")
+	assert.Len(t, p.Changes(), 2)
+
+	rec := httptest.NewRecorder()
+	p.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/plan", nil))
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	served := []*Change{}
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &served))
+	assert.Len(t, served, 2)
+	assert.Equal(t, ActionDelete, served[0].Action)
+	assert.Equal(t, KindSecurity, served[0].Kind)
+
+	// The nil plan ignores the resolving
+	var disabled *Plan
+	disabled.Resolve(KindNAT, "test", "k8s-140.145.20.10")
+}
@@ -0,0 +1,128 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package syncpolicy
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/golang/glog"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Store provides the SyncPolicies from the informer cache. The nil Store has no policies,
+// so that the controllers fall back to the flags when SyncPolicy is disabled.
+type Store struct {
+	informer informers.GenericInformer
+}
+
+// NewStore creates an instance of the SyncPolicy store
+func NewStore(informer informers.GenericInformer) *Store {
+	return &Store{informer: informer}
+}
+
+// HasSynced returns true when the SyncPolicy informer cache has been synced
+func (s *Store) HasSynced() bool {
+	if s == nil {
+		return true
+	}
+	return s.informer.Informer().HasSynced()
+}
+
+// AddEventHandler adds the handler for the changes of SyncPolicies
+func (s *Store) AddEventHandler(handler cache.ResourceEventHandler) {
+	if s == nil {
+		return
+	}
+	s.informer.Informer().AddEventHandler(handler)
+}
+
+// List returns all valid SyncPolicies
+func (s *Store) List() []*SyncPolicy {
+	if s == nil {
+		return nil
+	}
+
+	objs, err := s.informer.Lister().List(labels.Everything())
+	if err != nil {
+		glog.Warningf("Failed to list SyncPolicies: %s", err.Error())
+		return nil
+	}
+
+	policies := []*SyncPolicy{}
+	for _, obj := range objs {
+		policy, err := Convert(obj)
+		if err != nil {
+			glog.Warningf("Skipped the invalid SyncPolicy: %s", err.Error())
+			continue
+		}
+		policies = append(policies, policy)
+	}
+	return policies
+}
+
+// Match returns the SyncPolicy of the service in namespace, or nil if no policy matches. When
+// multiple policies match, the one with the highest priority wins, and the ties are broken by name.
+func (s *Store) Match(ns *v1.Namespace, svc *v1.Service) *SyncPolicy {
+	matched := []*SyncPolicy{}
+	for _, policy := range s.List() {
+		ok, err := policy.Matches(ns, svc)
+		if err != nil {
+			glog.Warningf("Skipped the SyncPolicy '%s' with invalid selector: %s", policy.Name, err.Error())
+			continue
+		}
+
+		if ok {
+			matched = append(matched, policy)
+		}
+	}
+
+	if len(matched) == 0 {
+		return nil
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		if matched[i].Spec.Priority != matched[j].Spec.Priority {
+			return matched[i].Spec.Priority > matched[j].Spec.Priority
+		}
+		return matched[i].Name < matched[j].Name
+	})
+	return matched[0]
+}
+
+// Convert converts the object from the informer to SyncPolicy
+func Convert(obj interface{}) (*SyncPolicy, error) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, fmt.Errorf("unexpected object type %T", obj)
+	}
+
+	policy := &SyncPolicy{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.UnstructuredContent(), policy); err != nil {
+		return nil, fmt.Errorf("failed to convert '%s': %s", u.GetName(), err.Error())
+	}
+	return policy, nil
+}
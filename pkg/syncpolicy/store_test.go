@@ -0,0 +1,112 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package syncpolicy
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func newUnstructured(t *testing.T, policy *SyncPolicy) *unstructured.Unstructured {
+	policy.APIVersion = GroupVersionResource.GroupVersion().String()
+	policy.Kind = "SyncPolicy"
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(policy)
+	assert.Nil(t, err)
+	return &unstructured.Unstructured{Object: content}
+}
+
+func TestStoreMatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	policies := []*SyncPolicy{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "default"},
+			Spec: SyncPolicySpec{
+				Security: SecurityPolicy{Applications: []string{"any"}},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "tenant"},
+			Spec: SyncPolicySpec{
+				Priority:          10,
+				NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"tenant": "true"}},
+				Security:          SecurityPolicy{DestinationZones: []string{"tenant zone"}},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "web"},
+			Spec: SyncPolicySpec{
+				Priority:          20,
+				NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"tenant": "true"}},
+				ServiceSelector:   &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+				NAT:               NATPolicy{DestinationZone: "dmz"},
+			},
+		},
+	}
+
+	objs := []runtime.Object{}
+	for _, policy := range policies {
+		objs = append(objs, newUnstructured(t, policy))
+	}
+
+	dynamicset := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), objs...)
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(dynamicset, 0)
+	store := NewStore(factory.ForResource(GroupVersionResource))
+	go factory.Start(ctx.Done())
+	assert.True(t, cache.WaitForCacheSync(ctx.Done(), store.HasSynced))
+	assert.Len(t, store.List(), 3)
+
+	tenant := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "tenant", Labels: map[string]string{"tenant": "true"}}}
+	other := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "other"}}
+	web := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "web", Labels: map[string]string{"app": "web"}}}
+	db := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "db"}}
+
+	tests := []struct {
+		Namespace *v1.Namespace
+		Service   *v1.Service
+		Expected  string
+	}{
+		{Namespace: tenant, Service: web, Expected: "web"},
+		{Namespace: tenant, Service: db, Expected: "tenant"},
+		{Namespace: tenant, Service: nil, Expected: "tenant"},
+		{Namespace: other, Service: web, Expected: "default"},
+	}
+
+	for _, test := range tests {
+		policy := store.Match(test.Namespace, test.Service)
+		assert.NotNil(t, policy)
+		assert.Equal(t, test.Expected, policy.Name)
+	}
+
+	matched := store.Match(tenant, web)
+	assert.Equal(t, "dmz", matched.Spec.NAT.DestinationZone)
+
+	// The nil store has no policies
+	var empty *Store
+	assert.True(t, empty.HasSynced())
+	assert.Nil(t, empty.Match(tenant, web))
+}
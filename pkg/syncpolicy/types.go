@@ -0,0 +1,100 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package syncpolicy
+
+import (
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// GroupVersionResource is the resource of SyncPolicy
+var GroupVersionResource = schema.GroupVersionResource{
+	Group:    "inwinstack.com",
+	Version:  "v1",
+	Resource: "syncpolicies",
+}
+
+// SyncPolicy is the cluster-scoped policy for building the NAT and Security of Kubernetes services
+type SyncPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec SyncPolicySpec `json:"spec"`
+}
+
+// SyncPolicySpec is the spec of SyncPolicy. The empty fields fall back to the flags of syncker.
+type SyncPolicySpec struct {
+	// Priority decides the policy when multiple policies match a service, the higher one wins
+	Priority int `json:"priority,omitempty"`
+	// NamespaceSelector selects the namespaces of services, the empty selector matches all namespaces
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// ServiceSelector selects the services, the empty selector matches all services
+	ServiceSelector *metav1.LabelSelector `json:"serviceSelector,omitempty"`
+
+	NAT      NATPolicy      `json:"nat,omitempty"`
+	Security SecurityPolicy `json:"security,omitempty"`
+}
+
+// NATPolicy contains the default fields of NAT
+type NATPolicy struct {
+	SourceZones     []string `json:"sourceZones,omitempty"`
+	DestinationZone string   `json:"destinationZone,omitempty"`
+}
+
+// SecurityPolicy contains the default fields of Security
+type SecurityPolicy struct {
+	SourceZones      []string `json:"sourceZones,omitempty"`
+	DestinationZones []string `json:"destinationZones,omitempty"`
+	SourceUsers      []string `json:"sourceUsers,omitempty"`
+	HipProfiles      []string `json:"hipProfiles,omitempty"`
+	Applications     []string `json:"applications,omitempty"`
+	Categories       []string `json:"categories,omitempty"`
+	LogSetting       string   `json:"logSetting,omitempty"`
+	Group            string   `json:"group,omitempty"`
+}
+
+// Matches checks whether the policy selects the service in namespace. When the service is nil,
+// only the policies without service selector are matched.
+func (p *SyncPolicy) Matches(ns *v1.Namespace, svc *v1.Service) (bool, error) {
+	if svc == nil && !isEmptySelector(p.Spec.ServiceSelector) {
+		return false, nil
+	}
+
+	ok, err := selectorMatches(p.Spec.NamespaceSelector, ns.Labels)
+	if err != nil || !ok || svc == nil {
+		return ok, err
+	}
+	return selectorMatches(p.Spec.ServiceSelector, svc.Labels)
+}
+
+func isEmptySelector(selector *metav1.LabelSelector) bool {
+	return selector == nil || (len(selector.MatchLabels) == 0 && len(selector.MatchExpressions) == 0)
+}
+
+func selectorMatches(selector *metav1.LabelSelector, set map[string]string) (bool, error) {
+	if isEmptySelector(selector) {
+		return true, nil
+	}
+
+	s, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return false, err
+	}
+	return s.Matches(labels.Set(set)), nil
+}
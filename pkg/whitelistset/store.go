@@ -0,0 +1,84 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package whitelistset
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Store provides the WhitelistSets from the informer cache. The nil Store has no sets, so that the
+// namespaces referencing any set are rejected when WhitelistSet is disabled.
+type Store struct {
+	informer informers.GenericInformer
+}
+
+// NewStore creates an instance of the WhitelistSet store
+func NewStore(informer informers.GenericInformer) *Store {
+	return &Store{informer: informer}
+}
+
+// HasSynced returns true when the WhitelistSet informer cache has been synced
+func (s *Store) HasSynced() bool {
+	if s == nil {
+		return true
+	}
+	return s.informer.Informer().HasSynced()
+}
+
+// AddEventHandler adds the handler for the changes of WhitelistSets
+func (s *Store) AddEventHandler(handler cache.ResourceEventHandler) {
+	if s == nil {
+		return
+	}
+	s.informer.Informer().AddEventHandler(handler)
+}
+
+// Get returns the WhitelistSet by name
+func (s *Store) Get(name string) (*WhitelistSet, error) {
+	if s == nil {
+		return nil, fmt.Errorf("WhitelistSet '%s' is referenced but WhitelistSet is disabled", name)
+	}
+
+	obj, err := s.informer.Lister().Get(name)
+	if err != nil {
+		return nil, err
+	}
+	return Convert(obj)
+}
+
+// Convert converts the object from the informer to WhitelistSet
+func Convert(obj interface{}) (*WhitelistSet, error) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, fmt.Errorf("unexpected object type %T", obj)
+	}
+
+	set := &WhitelistSet{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.UnstructuredContent(), set); err != nil {
+		return nil, fmt.Errorf("failed to convert '%s': %s", u.GetName(), err.Error())
+	}
+	return set, nil
+}
@@ -0,0 +1,69 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package whitelistset
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func newUnstructured(t *testing.T, set *WhitelistSet) *unstructured.Unstructured {
+	set.APIVersion = GroupVersionResource.GroupVersion().String()
+	set.Kind = "WhitelistSet"
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(set)
+	assert.Nil(t, err)
+	return &unstructured.Unstructured{Object: content}
+}
+
+func TestStoreGet(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	set := &WhitelistSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "office"},
+		Spec:       WhitelistSetSpec{Addresses: []string{"172.22.0.0/16", "group:office-vpn"}},
+	}
+	dynamicset := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), newUnstructured(t, set))
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(dynamicset, 0)
+	store := NewStore(factory.ForResource(GroupVersionResource))
+	go factory.Start(ctx.Done())
+	assert.True(t, cache.WaitForCacheSync(ctx.Done(), store.HasSynced))
+
+	office, err := store.Get("office")
+	assert.Nil(t, err)
+	assert.Equal(t, set.Spec.Addresses, office.Spec.Addresses)
+
+	_, err = store.Get("lab")
+	assert.NotNil(t, err)
+
+	_, err = Convert(cache.DeletedFinalStateUnknown{Key: "office", Obj: newUnstructured(t, set)})
+	assert.Nil(t, err)
+
+	// The nil store rejects the references
+	var empty *Store
+	assert.True(t, empty.HasSynced())
+	_, err = empty.Get("office")
+	assert.NotNil(t, err)
+}
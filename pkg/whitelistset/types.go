@@ -0,0 +1,44 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package whitelistset
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// GroupVersionResource is the resource of WhitelistSet
+var GroupVersionResource = schema.GroupVersionResource{
+	Group:    "inwinstack.com",
+	Version:  "v1",
+	Resource: "whitelistsets",
+}
+
+// WhitelistSet is the cluster-scoped list of source addresses, which is shared by the namespaces
+// referencing it in the whitelist sets annotation
+type WhitelistSet struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec WhitelistSetSpec `json:"spec"`
+}
+
+// WhitelistSetSpec is the spec of WhitelistSet
+type WhitelistSetSpec struct {
+	// Addresses are the entries of whitelist, which take the same syntax as the whitelist annotation
+	Addresses []string `json:"addresses,omitempty"`
+}